@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/spf13/cobra"
+)
+
+func addMetadataFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayP("metadata", "", []string{}, "Add global metadata inline (multiple key=value pairs can be provided)")
+	cmd.Flags().StringArrayP("metadata-file", "", []string{}, "YAML or JSON file with global metadata (can be repeated, later files win)")
+}
+
+// setMetadataOptions reads the global metadata from the environment and the
+// flags. Later sources win: TRACKMAN_META_ variables, then the metadata files
+// in order and then the inline metadata. All of them win over the workflow
+func setMetadataOptions(cmd *cobra.Command, options *utils.WorkflowOptions) error {
+	for key, value := range utils.MetadataFromEnv(os.Environ()) {
+		options.AddMetadata(utils.Metadata{key: value}, "env "+utils.MetadataEnvName(key))
+	}
+
+	files, _ := cmd.Flags().GetStringArray("metadata-file")
+	for _, file := range files {
+		metadata, err := utils.ReadMetadataFile(file)
+		if err != nil {
+			return err
+		}
+		options.AddMetadata(metadata, "file "+file)
+	}
+
+	flags, _ := cmd.Flags().GetStringArray("metadata")
+	values, err := parseKeyValues(flags, "metadata")
+	if err != nil {
+		return err
+	}
+
+	inline := make(utils.Metadata, len(values))
+	for key, value := range values {
+		inline[key] = value
+	}
+	options.AddMetadata(inline, "--metadata")
+
+	return nil
+}
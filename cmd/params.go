@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+)
+
+// promptTries is the number of times an invalid param value is asked for again
+const promptTries = 3
+
+func addParamFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayP("param", "p", []string{}, "Set a workflow param (multiple key=value pairs can be provided)")
+	cmd.Flags().StringArrayP("params-file", "", []string{}, "YAML or JSON file with the values of workflow params (can be repeated, later files win)")
+}
+
+// setParamOptions reads the params from the flags and prompts for missing
+// required params when running in a terminal
+func setParamOptions(cmd *cobra.Command, options *utils.WorkflowOptions) error {
+	params := make(map[string]interface{})
+
+	paramsFiles, _ := cmd.Flags().GetStringArray("params-file")
+	for _, paramsFile := range paramsFiles {
+		values, err := utils.ReadParamsFile(paramsFile)
+		if err != nil {
+			return err
+		}
+		for key, value := range values {
+			params[key] = value
+		}
+	}
+
+	flags, _ := cmd.Flags().GetStringArray("param")
+	values, err := parseKeyValues(flags, "param")
+	if err != nil {
+		return err
+	}
+	// params on the command line win over the params files
+	for key, value := range values {
+		params[key] = value
+	}
+
+	options.Params = params
+	if isatty.IsTerminal(os.Stdin.Fd()) && isatty.IsTerminal(os.Stdout.Fd()) {
+		options.Prompt = promptParam
+	}
+
+	return nil
+}
+
+// parseKeyValues parses key=value pairs. Values can have = in them
+func parseKeyValues(pairs []string, kind string) (map[string]string, error) {
+	values := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		keyValue := strings.SplitN(pair, "=", 2)
+		if len(keyValue) != 2 || keyValue[0] == "" {
+			return nil, fmt.Errorf("invalid %s %s. It should be key=value", kind, pair)
+		}
+		values[keyValue[0]] = keyValue[1]
+	}
+
+	return values, nil
+}
+
+// promptParam asks for the value of a param on the terminal until it's valid
+func promptParam(param *utils.ParamDefinition) (string, error) {
+	reader := bufio.NewReader(os.Stdin)
+
+	question := param.Name
+	if param.Description != "" {
+		question = fmt.Sprintf("%s (%s)", param.Name, param.Description)
+	}
+	switch param.Type {
+	case utils.ParamEnum:
+		question = fmt.Sprintf("%s [%s]", question, strings.Join(param.Values, "/"))
+	case utils.ParamList:
+		question = fmt.Sprintf("%s [comma separated]", question)
+	case utils.ParamBool:
+		question = fmt.Sprintf("%s [true/false]", question)
+	}
+
+	var err error
+	for tries := 0; tries < promptTries; tries++ {
+		fmt.Printf("%s: ", question)
+
+		answer, readErr := reader.ReadString('\n')
+		if readErr != nil {
+			return "", readErr
+		}
+		answer = strings.TrimSpace(answer)
+
+		if _, err = param.Convert(answer); err == nil && answer != "" {
+			return answer, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("param %s is required", param.Name)
+		}
+		utils.PrintError(err.Error())
+	}
+
+	return "", err
+}
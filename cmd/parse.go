@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sort"
 
 	"github.com/cloud66-oss/trackman/notifiers"
 	"github.com/cloud66-oss/trackman/utils"
@@ -23,6 +24,8 @@ var (
 
 func init() {
 	parseCmd.Flags().StringVarP(&parsingWorkflowFile, "file", "f", "", "workflow file to parse")
+	addMetadataFlags(parseCmd)
+	addParamFlags(parseCmd)
 
 	rootCmd.AddCommand(parseCmd)
 }
@@ -34,6 +37,15 @@ func parseExec(cmd *cobra.Command, args []string) {
 		Notifier: notifiers.ConsoleNotify,
 	}
 
+	if err := setMetadataOptions(cmd, options); err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+	if err := setParamOptions(cmd, options); err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
 	workflow, err := loadWorkflow(ctx, args, options, cmd)
 	if err != nil {
 		utils.PrintError(err.Error())
@@ -59,5 +71,20 @@ func parseExec(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	fmt.Println(string(buff))
+	fmt.Println(workflow.Masker().Mask(string(buff)))
+
+	// as comments so the output is still valid YAML
+	sources := workflow.MetadataSources()
+	if len(sources) > 0 {
+		paths := make([]string, 0, len(sources))
+		for path := range sources {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		fmt.Println("# metadata sources:")
+		for _, path := range paths {
+			fmt.Printf("#   %s: %s\n", path, sources[path])
+		}
+	}
 }
@@ -62,8 +62,8 @@ func initConfig() {
 
 func checkForUpdates(cmd *cobra.Command, args []string) {
 	if utils.Channel != "dev" && cmd.Name() != "update" && cmd.Name() != "version" && !viper.GetBool("no-update") {
+		UpdateDone.Add(1)
 		go func() {
-			UpdateDone.Add(1)
 			defer UpdateDone.Done()
 
 			update(true)
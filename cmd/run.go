@@ -2,23 +2,32 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
 
+	"github.com/cloud66-oss/trackman/metrics"
 	"github.com/cloud66-oss/trackman/notifiers"
+	"github.com/cloud66-oss/trackman/reports"
+	"github.com/cloud66-oss/trackman/tracing"
+	"github.com/cloud66-oss/trackman/tui"
 	"github.com/cloud66-oss/trackman/utils"
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var runCmd = &cobra.Command{
-	Use:   "run",
-	Short: "Run the given workflow",
-	Run:   runExec,
+	Use:     "run",
+	Short:   "Run the given workflow",
+	PreRunE: checkRunFlags,
+	Run:     runExec,
 }
 
 var (
@@ -30,7 +39,19 @@ func init() {
 	runCmd.Flags().DurationP("timeout", "", 10*time.Second, "global timeout unless overwritten by a step")
 	runCmd.Flags().IntP("concurrency", "", runtime.NumCPU()-1, "maximum number of concurrent steps to run")
 	runCmd.Flags().BoolP("yes", "y", false, "Answer Yes to all confirmation questions")
-	runCmd.Flags().StringArrayP("metadata", "", []string{}, "Add global metadata inline (multiple key=value pairs can be provided)")
+	runCmd.Flags().BoolP("no-summary", "", false, "Don't show the summary table of all steps at the end of the run")
+	runCmd.Flags().StringP("metrics-textfile", "", "", "Write Prometheus metrics of the run to the given file (node_exporter textfile format)")
+	runCmd.Flags().StringSliceP("metrics-labels", "", []string{}, "Metadata keys to add as labels to the metrics")
+	runCmd.Flags().StringP("otlp-endpoint", "", "", "Send a trace of the run to this OpenTelemetry collector (OTLP over HTTP, like http://localhost:4318)")
+	runCmd.Flags().StringArrayP("otlp-header", "", []string{}, "Add a header (key=value) to the requests sent to the OpenTelemetry collector")
+	runCmd.Flags().StringP("events", "", "", "Write all events as NDJSON to - (stdout), a file or a unix socket (unix:///path)")
+	runCmd.Flags().StringP("report-junit", "", "", "Write a JUnit XML report of the run to the given file")
+	runCmd.Flags().StringP("summary", "", "", "Write a summary of the run. Valid values are json and markdown")
+	runCmd.Flags().StringP("summary-file", "", "-", "File to write the summary to. Use - for stdout")
+	runCmd.Flags().BoolP("tui", "", false, "Show a live dashboard of the run in the terminal instead of the logs")
+	addMetadataFlags(runCmd)
+	addParamFlags(runCmd)
+	runCmd.Flags().StringP("secret-key-file", "", "", "key to decrypt encrypted secrets with (default is $HOME/.trackman/secret.key)")
 
 	_ = viper.BindPFlag("timeout", runCmd.Flags().Lookup("timeout"))
 	_ = viper.BindPFlag("concurrency", runCmd.Flags().Lookup("concurrency"))
@@ -39,50 +60,238 @@ func init() {
 	rootCmd.AddCommand(runCmd)
 }
 
-func runExec(cmd *cobra.Command, args []string) {
-	ctx := context.Background()
-
-	metadata, _ := cmd.Flags().GetStringArray("metadata")
-	customMetadata := make(map[string]string)
-	for _, m := range metadata {
-		keyValue := strings.Split(m, "=")
-		if len(keyValue) == 2 {
-			customMetadata[keyValue[0]] = keyValue[1]
-		}
+// checkRunFlags catches invalid flags before the workflow runs
+func checkRunFlags(cmd *cobra.Command, args []string) error {
+	summaryFormat, _ := cmd.Flags().GetString("summary")
+	if summaryFormat == "" {
+		return nil
+	}
+
+	if err := reports.CheckSummaryFormat(summaryFormat); err != nil {
+		return err
 	}
 
+	events, _ := cmd.Flags().GetString("events")
+	summaryFile, _ := cmd.Flags().GetString("summary-file")
+	if (events == "-" || events == "stdout") && summaryFile == "-" {
+		return errors.New("--summary can't be written to stdout with the event stream on stdout. Use --summary-file")
+	}
+
+	return nil
+}
+
+func runExec(cmd *cobra.Command, args []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	options := &utils.WorkflowOptions{
-		Notifier:    notifiers.ConsoleNotify,
 		Concurrency: viper.GetInt("concurrency"),
 		Timeout:     viper.GetDuration("timeout"),
-		Metadata:    customMetadata,
 	}
+	options.SecretKeyFile, _ = cmd.Flags().GetString("secret-key-file")
 
-	workflow, err := loadWorkflow(ctx, args, options, cmd)
+	hub, err := buildNotifiers(cmd, options, cancel)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	options.Notifier = hub.Notify
+
+	exitCode := runWorkflow(ctx, cmd, args, options)
+	if err = hub.Close(); err != nil {
+		fmt.Println(err)
+	}
+	if err = utils.RemoveOutputFiles(); err != nil {
+		fmt.Println(err)
+	}
+
+	if exitCode != 0 {
+		cancel()
+		os.Exit(exitCode)
+	}
+}
+
+// buildNotifiers sets up the notifiers chosen by the flags and the configuration.
+// interrupt is used by the notifiers that can stop the run
+func buildNotifiers(cmd *cobra.Command, options *utils.WorkflowOptions, interrupt func()) (*notifiers.Hub, error) {
+	hub := notifiers.NewHub(notifiers.NotifierFunc(notifiers.ConsoleNotify))
+
+	events, _ := cmd.Flags().GetString("events")
+	useTUI, _ := cmd.Flags().GetBool("tui")
+	if useTUI {
+		if events == "-" || events == "stdout" {
+			return nil, errors.New("--tui can't be used with the event stream on stdout")
+		}
+
+		if isatty.IsTerminal(os.Stdout.Fd()) && isatty.IsTerminal(os.Stdin.Fd()) {
+			logType := viper.GetString("log-type")
+			if logType == "stdout" || logType == "stderr" {
+				// the dashboard takes over the terminal
+				viper.Set("log-type", "discard")
+			}
+
+			dashboard := tui.NewDashboard(os.Stdout, os.Stdin, interrupt)
+			hub.Add(dashboard)
+			options.Confirm = dashboard.Confirm
+		} else {
+			utils.PrintError("Not running in a terminal. Showing the logs instead of the dashboard")
+		}
+	}
+
+	if events != "" {
+		if (events == "-" || events == "stdout") && viper.GetString("log-type") == "stdout" {
+			// keep the event stream clean and send the human logs to stderr
+			viper.Set("log-type", "stderr")
+		}
+
+		stream, err := notifiers.NewEventStream(events)
+		if err != nil {
+			return nil, err
+		}
+
+		hub.Add(stream)
+	}
+
+	summaryFormat, _ := cmd.Flags().GetString("summary")
+	summaryFile, _ := cmd.Flags().GetString("summary-file")
+	if summaryFormat != "" && summaryFile == "-" && viper.GetString("log-type") == "stdout" {
+		// keep the summary clean and send the human logs and the table to stderr
+		viper.Set("log-type", "stderr")
+	}
+
+	otlpEndpoint, _ := cmd.Flags().GetString("otlp-endpoint")
+	if otlpEndpoint != "" {
+		headers := make(map[string]string)
+		otlpHeaders, _ := cmd.Flags().GetStringArray("otlp-header")
+		for _, header := range otlpHeaders {
+			keyValue := strings.SplitN(header, "=", 2)
+			if len(keyValue) != 2 {
+				return nil, fmt.Errorf("invalid otlp header %s", header)
+			}
+
+			headers[keyValue[0]] = keyValue[1]
+		}
+
+		tracer := tracing.NewTracer(tracing.NewOTLPExporter(otlpEndpoint, headers, "trackman"), os.Getenv(tracing.TraceParentEnv))
+		hub.Add(tracer)
+		options.SpinnerEnv = tracer.Env
+	}
+
+	metricsFile, _ := cmd.Flags().GetString("metrics-textfile")
+	if metricsFile != "" {
+		metricsLabels, _ := cmd.Flags().GetStringSlice("metrics-labels")
+		hub.Add(metrics.NewCollector(metrics.NewRegistry(), metricsLabels, metricsFile))
+	}
+
+	var definitions []map[string]interface{}
+	if err := viper.UnmarshalKey("notifiers", &definitions); err != nil {
+		return nil, err
+	}
+
+	configured, err := notifiers.LoadNotifiers(definitions)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, notifier := range configured {
+		hub.Add(notifier)
+	}
+
+	return hub, nil
+}
+
+func runWorkflow(ctx context.Context, cmd *cobra.Command, args []string, options *utils.WorkflowOptions) int {
+	if err := setMetadataOptions(cmd, options); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := setParamOptions(cmd, options); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	workflow, err := loadWorkflow(ctx, args, options, cmd)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
 
 	logger, err := utils.NewLogger(workflow.Logger, utils.NewLoggingContext(workflow, nil))
 	if err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		return 1
 	}
 
 	err, stepErrors := workflow.Run(ctx)
+	if reportErr := writeReports(cmd, workflow); reportErr != nil {
+		logger.Error(reportErr)
+	}
+
 	if err != nil {
 		logger.Error(err)
-		os.Exit(1)
+		return 1
 	}
 
 	if stepErrors != nil {
 		// this is already logged, just get out
 		logger.Error("Done with errors")
-		os.Exit(1)
-	} else {
-		logger.Info("Done")
+		return 1
+	}
+
+	logger.Info("Done")
+
+	return 0
+}
+
+func writeReports(cmd *cobra.Command, workflow *utils.Workflow) error {
+	report := workflow.Report()
+
+	noSummary, _ := cmd.Flags().GetBool("no-summary")
+	if !noSummary {
+		writer := color.Output
+		if viper.GetString("log-type") == "stderr" {
+			writer = color.Error
+		}
+
+		if err := reports.WriteTable(writer, report); err != nil {
+			return err
+		}
 	}
+
+	junitFile, _ := cmd.Flags().GetString("report-junit")
+	if junitFile != "" {
+		file, err := os.Create(junitFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if err = reports.WriteJUnit(file, report); err != nil {
+			return err
+		}
+	}
+
+	summaryFormat, _ := cmd.Flags().GetString("summary")
+	if summaryFormat != "" {
+		summaryFile, _ := cmd.Flags().GetString("summary-file")
+
+		writer := io.Writer(os.Stdout)
+		if summaryFile != "-" {
+			file, err := os.Create(summaryFile)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			writer = file
+		}
+
+		if err := reports.WriteSummary(writer, summaryFormat, report); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func loadWorkflow(ctx context.Context, args []string, options *utils.WorkflowOptions, cmd *cobra.Command) (*utils.Workflow, error) {
@@ -102,5 +311,30 @@ func loadWorkflow(ctx context.Context, args []string, options *utils.WorkflowOpt
 		}
 	}
 
-	return utils.LoadWorkflowFromReader(ctx, options, reader)
+	if file != "-" {
+		// secret files are relative to the workflow
+		options.BaseDir = filepath.Dir(file)
+		options.File = file
+	}
+
+	workflow, err := utils.LoadWorkflowFromReader(ctx, options, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if workflow.Name == "" {
+		workflow.Name = workflowName(file)
+	}
+
+	return workflow, nil
+}
+
+// workflowName returns a name for a workflow based on its file name
+func workflowName(file string) string {
+	if file == "-" || file == "" {
+		return "workflow"
+	}
+
+	base := filepath.Base(file)
+	return strings.TrimSuffix(base, filepath.Ext(base))
 }
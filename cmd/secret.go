@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/spf13/cobra"
+)
+
+var secretCmd = &cobra.Command{
+	Use:   "secret",
+	Short: "Manage encrypted secrets of workflows",
+}
+
+var secretKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Create a new key pair to encrypt and decrypt secrets with",
+	Args:  cobra.NoArgs,
+	Run:   secretKeygenExec,
+}
+
+var secretEncryptCmd = &cobra.Command{
+	Use:   "encrypt [file]",
+	Short: "Encrypt a secret from a file or stdin with a public key",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   secretEncryptExec,
+}
+
+var secretDecryptCmd = &cobra.Command{
+	Use:   "decrypt [file]",
+	Short: "Decrypt a secret from a file or stdin with the private key",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   secretDecryptExec,
+}
+
+func init() {
+	secretCmd.PersistentFlags().StringP("key-file", "k", "", "private key file (default is $HOME/.trackman/secret.key)")
+	secretEncryptCmd.Flags().StringP("public-key-file", "p", "", "public key file (default is the key file with .pub)")
+	secretEncryptCmd.Flags().StringP("out", "o", "-", "file to write the encrypted secret to. Use - for stdout")
+	secretDecryptCmd.Flags().StringP("out", "o", "-", "file to write the secret to. Use - for stdout")
+
+	secretCmd.AddCommand(secretKeygenCmd)
+	secretCmd.AddCommand(secretEncryptCmd)
+	secretCmd.AddCommand(secretDecryptCmd)
+	rootCmd.AddCommand(secretCmd)
+}
+
+func secretKeygenExec(cmd *cobra.Command, args []string) {
+	keyFile, err := secretKeyFile(cmd)
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	if err = utils.GenerateSecretKey(keyFile); err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("Private key written to %s. Keep it safe and share it only with those who run the workflows\n", keyFile)
+	fmt.Printf("Public key written to %s. Anyone with it can encrypt secrets\n", keyFile+utils.PublicKeySuffix)
+}
+
+func secretEncryptExec(cmd *cobra.Command, args []string) {
+	publicKeyFile, _ := cmd.Flags().GetString("public-key-file")
+	if publicKeyFile == "" {
+		keyFile, err := secretKeyFile(cmd)
+		if err != nil {
+			utils.PrintError(err.Error())
+			os.Exit(1)
+		}
+		publicKeyFile = keyFile + utils.PublicKeySuffix
+	}
+
+	key, err := utils.LoadPublicKey(publicKeyFile)
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	secretTransform(cmd, args, key, utils.EncryptSecret, 0644)
+}
+
+func secretDecryptExec(cmd *cobra.Command, args []string) {
+	keyFile, err := secretKeyFile(cmd)
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	key, err := utils.LoadSecretKey(keyFile)
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	secretTransform(cmd, args, key, utils.DecryptSecret, 0600)
+}
+
+// secretTransform reads the input, runs it through transform with the key and writes the result
+func secretTransform(cmd *cobra.Command, args []string, key []byte, transform func(key []byte, data []byte) ([]byte, error), mode os.FileMode) {
+	var err error
+	var input []byte
+	if len(args) == 0 || args[0] == "-" {
+		input, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		input, err = ioutil.ReadFile(args[0])
+	}
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	output, err := transform(key, input)
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	out, _ := cmd.Flags().GetString("out")
+	if out == "-" {
+		_, err = os.Stdout.Write(output)
+	} else {
+		err = ioutil.WriteFile(out, output, mode)
+	}
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+}
+
+func secretKeyFile(cmd *cobra.Command) (string, error) {
+	keyFile, _ := cmd.Flags().GetString("key-file")
+	if keyFile != "" {
+		return keyFile, nil
+	}
+
+	return utils.DefaultSecretKeyFile()
+}
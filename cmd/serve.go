@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/cloud66-oss/trackman/metrics"
+	"github.com/cloud66-oss/trackman/notifiers"
+	"github.com/cloud66-oss/trackman/server"
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run workflows submitted over an HTTP API",
+	Run:   serveExec,
+}
+
+func init() {
+	serveCmd.Flags().StringP("listen", "", ":8080", "address to listen on")
+	serveCmd.Flags().StringP("token", "", "", "require this bearer token for all API calls. Can also be set with TRACKMAN_API_TOKEN")
+	serveCmd.Flags().IntP("max-runs", "", 1, "maximum number of workflows to run at the same time")
+	serveCmd.Flags().DurationP("timeout", "", 10*time.Second, "global timeout unless overwritten by a step")
+	serveCmd.Flags().IntP("concurrency", "", defaultServeConcurrency(), "maximum number of concurrent steps to run in each workflow")
+	serveCmd.Flags().BoolP("yes", "y", false, "Answer Yes to all confirmation questions. Otherwise steps that ask to proceed are cancelled")
+	serveCmd.Flags().StringSliceP("metrics-labels", "", []string{}, "Metadata keys to add as labels to the metrics")
+	serveCmd.Flags().StringP("secret-key-file", "", "", "key to decrypt encrypted secrets with (default is $HOME/.trackman/secret.key)")
+	serveCmd.Flags().IntP("keep-runs", "", 100, "number of finished runs to keep. Older ones are forgotten")
+	serveCmd.Flags().DurationP("keep-runs-for", "", 24*time.Hour, "how long to keep finished runs. 0 keeps them until there are more than --keep-runs")
+	serveCmd.Flags().DurationP("shutdown-timeout", "", 30*time.Second, "time to wait for running workflows to stop when shutting down")
+
+	rootCmd.AddCommand(serveCmd)
+}
+
+// defaultServeConcurrency leaves a CPU for the server but is at least 1, as
+// runs can't start any steps without it
+func defaultServeConcurrency() int {
+	if runtime.NumCPU() > 1 {
+		return runtime.NumCPU() - 1
+	}
+
+	return 1
+}
+
+func serveExec(cmd *cobra.Command, args []string) {
+	listen, _ := cmd.Flags().GetString("listen")
+	token, _ := cmd.Flags().GetString("token")
+	if token == "" {
+		token = os.Getenv("TRACKMAN_API_TOKEN")
+	}
+	maxRuns, _ := cmd.Flags().GetInt("max-runs")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+	if concurrency < 1 {
+		fmt.Println("concurrency should be at least 1")
+		os.Exit(1)
+	}
+	yes, _ := cmd.Flags().GetBool("yes")
+	metricsLabels, _ := cmd.Flags().GetStringSlice("metrics-labels")
+	shutdownTimeout, _ := cmd.Flags().GetDuration("shutdown-timeout")
+	keepRuns, _ := cmd.Flags().GetInt("keep-runs")
+	keepRunsFor, _ := cmd.Flags().GetDuration("keep-runs-for")
+	secretKeyFile, _ := cmd.Flags().GetString("secret-key-file")
+
+	logger, err := utils.NewLogger(nil, utils.NewLoggingContext(nil, nil))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var definitions []map[string]interface{}
+	if err = viper.UnmarshalKey("notifiers", &definitions); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	api := server.NewServer(&server.Options{
+		Token:         token,
+		MaxRuns:       maxRuns,
+		Concurrency:   concurrency,
+		Timeout:       timeout,
+		Yes:           yes,
+		Registry:      metrics.NewRegistry(),
+		MetricsLabels: metricsLabels,
+		SecretKeyFile: secretKeyFile,
+		KeepRuns:      keepRuns,
+		KeepRunsFor:   keepRunsFor,
+		Notifiers: func() ([]notifiers.Notifier, error) {
+			return notifiers.LoadNotifiers(definitions)
+		},
+	}, logger)
+
+	httpServer := &http.Server{
+		Addr:    listen,
+		Handler: api,
+	}
+
+	stop := make(chan os.Signal, 1)
+	stopped := make(chan struct{})
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer close(stopped)
+
+		<-stop
+		logger.Info("Shutting down")
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := api.Shutdown(ctx); err != nil {
+			logger.Error(err)
+		}
+		if err := httpServer.Shutdown(ctx); err != nil {
+			logger.Error(err)
+		}
+		if err := utils.RemoveOutputFiles(); err != nil {
+			logger.Error(err)
+		}
+	}()
+
+	if token == "" {
+		logger.Warn("No token set. The API is open to anyone who can reach it")
+	}
+	logger.Infof("Listening on %s", listen)
+
+	err = httpServer.ListenAndServe()
+	if err != http.ErrServerClosed {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	<-stopped
+}
@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// durationBuckets are histogram buckets (in seconds) suitable for steps and workflows
+	durationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+	invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// Collector turns workflow events into metrics. It can be added to the
+// notifiers of a workflow
+type Collector struct {
+	registry     *Registry
+	metadataKeys []string
+	textfile     string
+
+	workflowRuns     *CounterVec
+	workflowDuration *HistogramVec
+	stepRuns         *CounterVec
+	stepDuration     *HistogramVec
+	stepRetries      *CounterVec
+	stepQueueWait    *HistogramVec
+	probeAttempts    *CounterVec
+}
+
+// NewCollector creates a new Collector that registers its metrics with registry.
+// The values of metadataKeys are added as labels (named meta_<key>) to the metrics.
+// If textfile is not empty, the metrics are written to it in the node_exporter
+// textfile format when the collector is closed
+func NewCollector(registry *Registry, metadataKeys []string, textfile string) *Collector {
+	var metaLabels []string
+	for _, key := range metadataKeys {
+		metaLabels = append(metaLabels, LabelName(key))
+	}
+
+	workflowLabels := append([]string{"workflow"}, metaLabels...)
+	stepLabels := append([]string{"workflow", "step"}, metaLabels...)
+
+	return &Collector{
+		registry:     registry,
+		metadataKeys: metadataKeys,
+		textfile:     textfile,
+
+		workflowRuns:     registry.NewCounterVec("trackman_workflow_runs_total", "Number of workflow runs", append(workflowLabels, "status")),
+		workflowDuration: registry.NewHistogramVec("trackman_workflow_duration_seconds", "Duration of workflow runs", durationBuckets, workflowLabels),
+		stepRuns:         registry.NewCounterVec("trackman_step_runs_total", "Number of step runs", append(stepLabels, "status")),
+		stepDuration:     registry.NewHistogramVec("trackman_step_duration_seconds", "Duration of step runs", durationBuckets, stepLabels),
+		stepRetries:      registry.NewCounterVec("trackman_step_retries_total", "Number of step runs after the first attempt", stepLabels),
+		stepQueueWait:    registry.NewHistogramVec("trackman_step_queue_wait_seconds", "Time steps waited to run once their dependencies were done", durationBuckets, stepLabels),
+		probeAttempts:    registry.NewCounterVec("trackman_probe_attempts_total", "Number of probe runs", append(stepLabels, "status")),
+	}
+}
+
+// LabelName turns a metadata key into a valid label name
+func LabelName(key string) string {
+	return "meta_" + invalidLabelChars.ReplaceAllString(key, "_")
+}
+
+// Notify implements notifiers.Notifier
+func (c *Collector) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	payload := event.Payload
+
+	switch event.Name {
+	case utils.EventWorkflowFinished, utils.EventWorkflowFailed:
+		labels := c.workflowLabels(payload.Workflow)
+		status := utils.StatusSuccess
+		if event.Name == utils.EventWorkflowFailed {
+			status = utils.StatusFailed
+		}
+
+		c.workflowRuns.Inc(append(labels, status)...)
+		c.workflowDuration.Observe(payload.Duration.Seconds(), labels...)
+	case utils.EventStepStarted:
+		c.stepQueueWait.Observe(payload.QueueWait.Seconds(), c.stepLabels(payload.Step)...)
+	case utils.EventRunSuccess, utils.EventRunFail, utils.EventRunTimeout, utils.EventRunError, utils.EventRunWaitError:
+		labels := c.stepLabels(payload.Step)
+		status := runStatus(event.Name)
+
+		switch payload.Spinner.Kind {
+		case utils.SpinnerKindStep:
+			c.stepRuns.Inc(append(labels, status)...)
+			c.stepDuration.Observe(payload.Duration.Seconds(), labels...)
+			if payload.Attempt > 1 {
+				c.stepRetries.Inc(labels...)
+			}
+		case utils.SpinnerKindProbe:
+			c.probeAttempts.Inc(append(labels, status)...)
+		}
+	}
+
+	return nil
+}
+
+// Close implements notifiers.Notifier
+func (c *Collector) Close() error {
+	if c.textfile == "" {
+		return nil
+	}
+
+	return c.WriteTextfile(c.textfile)
+}
+
+// WriteTextfile writes the metrics to a file. The file is replaced atomically
+// so node_exporter never reads a partial file
+func (c *Collector) WriteTextfile(path string) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if err = c.registry.WriteText(file); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err = os.Chmod(file.Name(), 0644); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+func (c *Collector) workflowLabels(workflow *utils.Workflow) []string {
+	// nested metadata is used as db.host
+	metadata := workflow.Metadata.Flatten()
+
+	labels := []string{workflow.Name}
+	for _, key := range c.metadataKeys {
+		labels = append(labels, workflow.Masker().Mask(metadata[key]))
+	}
+
+	return labels
+}
+
+func (c *Collector) stepLabels(step *utils.Step) []string {
+	metadata := step.MergedMetadata()
+
+	labels := []string{step.Workflow().Name, step.Name}
+	for _, key := range c.metadataKeys {
+		labels = append(labels, step.Workflow().Masker().Mask(metadata[key]))
+	}
+
+	return labels
+}
+
+func runStatus(eventName string) string {
+	switch eventName {
+	case utils.EventRunSuccess:
+		return utils.StatusSuccess
+	case utils.EventRunTimeout:
+		return "timeout"
+	case utils.EventRunError, utils.EventRunWaitError:
+		return "error"
+	default:
+		return utils.StatusFailed
+	}
+}
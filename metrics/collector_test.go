@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+func TestCollectorStepRuns(t *testing.T) {
+	viper.Set("log-type", "discard")
+	viper.Set("log-level", "info")
+	viper.Set("log-format", "text")
+
+	registry := NewRegistry()
+	collector := NewCollector(registry, nil, "")
+
+	ctx := context.Background()
+	options := &utils.WorkflowOptions{Notifier: collector.Notify, Timeout: time.Second}
+	workflow, err := utils.LoadWorkflowFromBytes(ctx, options, []byte(`
+version: 1
+name: deploy
+steps:
+  - name: build
+    command: "true"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step := workflow.Steps[0]
+	for _, run := range []struct {
+		name    string
+		attempt int
+	}{
+		{utils.EventRunFail, 1},
+		{utils.EventRunSuccess, 2},
+	} {
+		err = collector.Notify(ctx, logrus.New(), &utils.Event{
+			Name: run.name,
+			Payload: utils.Payload{
+				Workflow: workflow,
+				Step:     step,
+				Spinner:  &utils.Spinner{Kind: utils.SpinnerKindStep},
+				Attempt:  run.attempt,
+				Duration: time.Second,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	text := writeText(t, registry)
+	checkFormat(t, text)
+	for _, expected := range []string{
+		`trackman_step_runs_total{workflow="deploy",step="build",status="failed"} 1`,
+		`trackman_step_runs_total{workflow="deploy",step="build",status="success"} 1`,
+		// only the second attempt is a retry
+		`trackman_step_retries_total{workflow="deploy",step="build"} 1`,
+	} {
+		if !strings.Contains(text, expected+"\n") {
+			t.Errorf("expected %s in\n%s", expected, text)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metrics and writes them in the Prometheus text format
+type Registry struct {
+	metrics []metric
+	lock    sync.Mutex
+}
+
+type metric interface {
+	write(writer *bufio.Writer)
+}
+
+// NewRegistry creates a new Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounterVec registers and returns a new counter with the given labels
+func (r *Registry) NewCounterVec(name, help string, labels []string) *CounterVec {
+	counter := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		series: make(map[string]*counterSeries),
+	}
+
+	r.register(counter)
+
+	return counter
+}
+
+// NewHistogramVec registers and returns a new histogram with the given buckets and labels
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels []string) *HistogramVec {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+
+	histogram := &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: sorted,
+		series:  make(map[string]*histogramSeries),
+	}
+
+	r.register(histogram)
+
+	return histogram
+}
+
+func (r *Registry) register(m metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format
+func (r *Registry) WriteText(writer io.Writer) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	buf := bufio.NewWriter(writer)
+	for _, m := range r.metrics {
+		m.write(buf)
+	}
+
+	return buf.Flush()
+}
+
+// Handler returns an http.Handler that serves the metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.WriteText(w)
+	})
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	series map[string]*counterSeries
+	lock   sync.Mutex
+}
+
+type counterSeries struct {
+	labelValues []string
+	value       float64
+}
+
+// Inc increments the counter for the given label values by 1
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds value to the counter for the given label values
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := seriesKey(labelValues)
+	series, ok := c.series[key]
+	if !ok {
+		series = &counterSeries{labelValues: labelValues}
+		c.series[key] = series
+	}
+
+	series.value += value
+}
+
+func (c *CounterVec) write(writer *bufio.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	writeHeader(writer, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.series) {
+		series := c.series[key]
+		fmt.Fprintf(writer, "%s%s %s\n", c.name, formatLabels(c.labels, series.labelValues, "", ""), formatValue(series.value))
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*histogramSeries
+	lock    sync.Mutex
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// Observe adds a value to the histogram for the given label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	key := seriesKey(labelValues)
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{
+			labelValues: labelValues,
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = series
+	}
+
+	for idx, bound := range h.buckets {
+		if value <= bound {
+			series.counts[idx]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+func (h *HistogramVec) write(writer *bufio.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	writeHeader(writer, h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.series) {
+		series := h.series[key]
+		for idx, bound := range h.buckets {
+			fmt.Fprintf(writer, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, series.labelValues, "le", formatValue(bound)), series.counts[idx])
+		}
+		fmt.Fprintf(writer, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, series.labelValues, "le", "+Inf"), series.count)
+		fmt.Fprintf(writer, "%s_sum%s %s\n", h.name, formatLabels(h.labels, series.labelValues, "", ""), formatValue(series.sum))
+		fmt.Fprintf(writer, "%s_count%s %d\n", h.name, formatLabels(h.labels, series.labelValues, "", ""), series.count)
+	}
+}
+
+func writeHeader(writer *bufio.Writer, name, help, metricType string) {
+	fmt.Fprintf(writer, "# HELP %s %s\n", name, strings.NewReplacer("\\", `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(writer, "# TYPE %s %s\n", name, metricType)
+}
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	var pairs []string
+	for idx, name := range names {
+		value := ""
+		if idx < len(values) {
+			value = values[idx]
+		}
+
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(value)))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer("\\", `\\`, "\"", `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+func sortedKeys(series interface{}) []string {
+	var keys []string
+	switch typed := series.(type) {
+	case map[string]*counterSeries:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	case map[string]*histogramSeries:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// sampleLine matches a sample line of the Prometheus text format
+var sampleLine = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*(\{([a-zA-Z_][a-zA-Z0-9_]*="([^"\\\n]|\\["\\n])*",?)*\})? (-?[0-9.e+-]+|\+Inf|-Inf|NaN)$`)
+
+func writeText(t *testing.T, registry *Registry) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := registry.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func checkFormat(t *testing.T, text string) {
+	t.Helper()
+
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		if !sampleLine.MatchString(line) {
+			t.Errorf("invalid sample line %q", line)
+		}
+	}
+}
+
+func TestCounterText(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("test_runs_total", "Number of runs", []string{"workflow", "status"})
+	counter.Inc("deploy", "success")
+	counter.Inc("deploy", "success")
+	counter.Add(0.5, "build", "failed")
+
+	expected := `# HELP test_runs_total Number of runs
+# TYPE test_runs_total counter
+test_runs_total{workflow="build",status="failed"} 0.5
+test_runs_total{workflow="deploy",status="success"} 2
+`
+	text := writeText(t, registry)
+	if text != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, text)
+	}
+	checkFormat(t, text)
+}
+
+func TestCounterWithoutLabels(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounterVec("test_total", "Total", nil).Inc()
+
+	text := writeText(t, registry)
+	if !strings.Contains(text, "\ntest_total 1\n") {
+		t.Errorf("expected a sample without labels, got\n%s", text)
+	}
+	checkFormat(t, text)
+}
+
+func TestHistogramText(t *testing.T) {
+	registry := NewRegistry()
+	histogram := registry.NewHistogramVec("test_duration_seconds", "Duration", []float64{5, 1}, []string{"step"})
+	histogram.Observe(0.5, "build")
+	histogram.Observe(2, "build")
+	histogram.Observe(10, "build")
+
+	expected := `# HELP test_duration_seconds Duration
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{step="build",le="1"} 1
+test_duration_seconds_bucket{step="build",le="5"} 2
+test_duration_seconds_bucket{step="build",le="+Inf"} 3
+test_duration_seconds_sum{step="build"} 12.5
+test_duration_seconds_count{step="build"} 3
+`
+	text := writeText(t, registry)
+	if text != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, text)
+	}
+	checkFormat(t, text)
+}
+
+func TestEscaping(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("test_total", "Help with \\ and\nnew line", []string{"step"})
+	counter.Inc("say \"hi\"\nand \\ bye")
+
+	text := writeText(t, registry)
+	if !strings.Contains(text, "# HELP test_total Help with \\\\ and\\nnew line\n") {
+		t.Errorf("help isn't escaped:\n%s", text)
+	}
+	if !strings.Contains(text, `test_total{step="say \"hi\"\nand \\ bye"} 1`) {
+		t.Errorf("label value isn't escaped:\n%s", text)
+	}
+	checkFormat(t, text)
+}
+
+func TestLabelName(t *testing.T) {
+	if name := LabelName("cloud66.com/uuid"); name != "meta_cloud66_com_uuid" {
+		t.Errorf("expected meta_cloud66_com_uuid, got %s", name)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounterVec("test_total", "Total", []string{"step"}).Inc("build")
+
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %s", contentType)
+	}
+	if !strings.Contains(recorder.Body.String(), `test_total{step="build"} 1`) {
+		t.Errorf("unexpected body\n%s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,78 @@
+package notifiers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// LoadNotifiers creates notifiers from their configuration. Each definition
+// should have a type and the attributes for that type of notifier
+func LoadNotifiers(definitions []map[string]interface{}) ([]Notifier, error) {
+	var result []Notifier
+	for _, definition := range definitions {
+		notifier, err := loadNotifier(definition)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, notifier)
+	}
+
+	return result, nil
+}
+
+func loadNotifier(definition map[string]interface{}) (Notifier, error) {
+	notifierType, ok := definition["type"].(string)
+	if !ok {
+		return nil, errors.New("notifier has no type")
+	}
+
+	switch notifierType {
+	case "webhook":
+		config := &WebhookConfig{}
+		if err := decodeConfig(definition, config); err != nil {
+			return nil, err
+		}
+
+		return NewWebhookNotifier(config)
+	case "exec":
+		config := &ExecConfig{}
+		if err := decodeConfig(definition, config); err != nil {
+			return nil, err
+		}
+
+		return NewExecNotifier(config)
+	case "email":
+		config := &EmailConfig{}
+		if err := decodeConfig(definition, config); err != nil {
+			return nil, err
+		}
+
+		return NewEmailNotifier(config)
+	default:
+		return nil, fmt.Errorf("invalid notifier type %s", notifierType)
+	}
+}
+
+func decodeConfig(definition map[string]interface{}, config interface{}) error {
+	attributes := make(map[string]interface{}, len(definition))
+	for key, value := range definition {
+		if key != "type" {
+			attributes[key] = value
+		}
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           config,
+		WeaklyTypedInput: true,
+		ErrorUnused:      true,
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return decoder.Decode(attributes)
+}
@@ -19,14 +19,59 @@ func ConsoleNotify(ctx context.Context, logger *logrus.Logger, event *utils.Even
 	case utils.EventRunError:
 		logger.WithField(utils.FldStep, event.Payload.Spinner.Name).Error("Failed to run")
 	case utils.EventRunFail:
-		logger.WithField(utils.FldStep, event.Payload.Spinner.Name).Errorf("Finished with error %v", event.Payload.Extras)
+		logger.WithField(utils.FldStep, event.Payload.Spinner.Name).Errorf("Finished with error %s", event.Payload.Error)
+		logOutputTail(logger, event)
 	case utils.EventRunTimeout:
 		logger.WithField(utils.FldStep, event.Payload.Spinner.Name).Error("Timed out")
+		logOutputTail(logger, event)
 	case utils.EventRunWaitError:
 		logger.WithField(utils.FldStep, event.Payload.Spinner.Name).Error("Error during wait")
+		logOutputTail(logger, event)
 	case utils.EventRunningProbe:
 		logger.WithField(utils.FldStep, event.Payload.Spinner.Name).Debug("Running a probe")
+	case utils.EventWorkflowStarted:
+		logger.Debug("Workflow started")
+	case utils.EventWorkflowFinished:
+		logger.Debug("Workflow finished")
+	case utils.EventWorkflowFailed:
+		logger.Debugf("Workflow failed %s", event.Payload.Error)
+	case utils.EventPreflightStarted:
+		logger.Debug("Preflight checks started")
+	case utils.EventPreflightPassed:
+		logger.Debug("Preflight checks passed")
+	case utils.EventPreflightFailed:
+		logger.WithField(utils.FldStep, event.Payload.Step.Name).Debugf("Preflight check failed %s", event.Payload.Error)
+	case utils.EventStepStarted:
+		logger.WithField(utils.FldStep, event.Payload.Step.Name).Debug("Step started")
+	case utils.EventStepFinished:
+		logger.WithField(utils.FldStep, event.Payload.Step.Name).Debug("Step finished")
+	case utils.EventStepDisabled:
+		logger.WithField(utils.FldStep, event.Payload.Step.Name).Info("Disabled step. Skipping")
+	case utils.EventStepSkipped:
+		logger.WithField(utils.FldStep, event.Payload.Step.Name).Info("Skipped")
+	case utils.EventStepCancelled:
+		logger.WithField(utils.FldStep, event.Payload.Step.Name).Info("Cancelled")
+	case utils.EventConfirmationAsked:
+		logger.WithField(utils.FldStep, event.Payload.Step.Name).Debug("Asking for confirmation")
+	case utils.EventConfirmationAnswered:
+		logger.WithField(utils.FldStep, event.Payload.Step.Name).Debugf("Confirmation answered %v", *event.Payload.Confirmed)
 	}
 
 	return nil
 }
+
+// logOutputTail shows the last lines of output of a failed run, unless
+// they were already shown as they came
+func logOutputTail(logger *logrus.Logger, event *utils.Event) {
+	entry := logger.WithField(utils.FldStep, event.Payload.Spinner.Name)
+	if len(event.Payload.Output) > 0 && !event.Payload.Spinner.StdoutLogged() {
+		entry.Errorf("Last %d lines of output:", len(event.Payload.Output))
+		for _, line := range event.Payload.Output {
+			entry.Error(line)
+		}
+	}
+
+	if event.Payload.OutputFile != "" {
+		entry.Errorf("Full output is in %s", event.Payload.OutputFile)
+	}
+}
@@ -0,0 +1,230 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	emailTLSNone     = "none"
+	emailTLSStartTLS = "starttls"
+	emailTLSImplicit = "tls"
+
+	defaultEmailTimeout = 30 * time.Second
+)
+
+// EmailConfig is the configuration of an EmailNotifier
+type EmailConfig struct {
+	Host      string              `mapstructure:"host"`
+	Port      int                 `mapstructure:"port"`
+	TLS       string              `mapstructure:"tls"`
+	Username  string              `mapstructure:"username"`
+	Password  string              `mapstructure:"password"`
+	From      string              `mapstructure:"from"`
+	To        map[string][]string `mapstructure:"to"`
+	Timeout   time.Duration       `mapstructure:"timeout"`
+	QueueSize int                 `mapstructure:"queue_size"`
+}
+
+// EmailNotifier sends a summary mail of the workflow run when it finishes.
+// Recipients are picked by the event name (workflow.finished or workflow.failed)
+type EmailNotifier struct {
+	config *EmailConfig
+	queue  *asyncQueue
+}
+
+// NewEmailNotifier creates a new EmailNotifier
+func NewEmailNotifier(config *EmailConfig) (*EmailNotifier, error) {
+	if config.Host == "" {
+		return nil, errors.New("no host for email notifier")
+	}
+	if config.From == "" {
+		return nil, errors.New("no from address for email notifier")
+	}
+	if config.TLS == "" {
+		config.TLS = emailTLSStartTLS
+	}
+	if config.TLS != emailTLSNone && config.TLS != emailTLSStartTLS && config.TLS != emailTLSImplicit {
+		return nil, fmt.Errorf("invalid tls option %s for email notifier", config.TLS)
+	}
+	if config.Port == 0 {
+		config.Port = 25
+		if config.TLS == emailTLSImplicit {
+			config.Port = 465
+		}
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultEmailTimeout
+	}
+
+	return &EmailNotifier{
+		config: config,
+		queue:  newAsyncQueue(config.QueueSize),
+	}, nil
+}
+
+// Notify implements Notifier
+func (e *EmailNotifier) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	if event.Name != utils.EventWorkflowFinished && event.Name != utils.EventWorkflowFailed {
+		return nil
+	}
+
+	recipients := e.config.To[event.Name]
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	message := e.message(event.Payload.Workflow.Report(), recipients)
+	queued := e.queue.push(func() {
+		if err := e.send(recipients, message); err != nil {
+			logger.WithField("Event", event.Name).Errorf("Failed to send email: %s", err)
+		}
+	})
+	if !queued {
+		logger.WithField("Event", event.Name).Warn("Email queue is full. Dropping event")
+	}
+
+	return nil
+}
+
+// Close implements Notifier
+func (e *EmailNotifier) Close() error {
+	return e.queue.close(defaultCloseTimeout)
+}
+
+func (e *EmailNotifier) message(report *utils.WorkflowReport, recipients []string) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", e.config.From)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(buf, "Subject: Trackman: %s %s (%s)\r\n", report.Name, report.Status, report.SessionID)
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	body := &bytes.Buffer{}
+	fmt.Fprintf(body, "Workflow: %s\n", report.Name)
+	fmt.Fprintf(body, "Session ID: %s\n", report.SessionID)
+	fmt.Fprintf(body, "Status: %s\n", report.Status)
+	fmt.Fprintf(body, "Duration: %s\n", report.Duration.Round(time.Millisecond))
+	if report.Error != "" {
+		fmt.Fprintf(body, "Error: %s\n", report.Error)
+	}
+	body.WriteString("\n")
+
+	table := tabwriter.NewWriter(body, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "STEP\tSTATUS\tDURATION\tEXIT CODE")
+	for _, step := range report.Steps {
+		exitCode := ""
+		if step.ExitCode != nil {
+			exitCode = strconv.Itoa(*step.ExitCode)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", step.Name, step.Status, step.Duration.Round(time.Millisecond), exitCode)
+	}
+	table.Flush()
+
+	if step := report.FailedStep(); step != nil {
+		if run := step.FailedRun(); run != nil {
+			fmt.Fprintf(body, "\nOutput of %s (%s):\n", run.Name, run.Error)
+			for _, line := range run.Output {
+				fmt.Fprintf(body, "  %s\n", line)
+			}
+		}
+	}
+
+	buf.WriteString(strings.Replace(body.String(), "\n", "\r\n", -1))
+
+	return buf.Bytes()
+}
+
+func (e *EmailNotifier) send(recipients []string, message []byte) error {
+	address := net.JoinHostPort(e.config.Host, strconv.Itoa(e.config.Port))
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: e.config.Timeout}
+	if e.config.TLS == emailTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: e.config.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(e.config.Timeout))
+
+	client, err := smtp.NewClient(conn, e.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if e.config.TLS == emailTLSStartTLS {
+		// credentials and mail are never sent in plain text unless tls is none
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s doesn't support STARTTLS. Use tls: none to send mail without encryption", e.config.Host)
+		}
+		if err = client.StartTLS(&tls.Config{ServerName: e.config.Host}); err != nil {
+			return err
+		}
+	}
+
+	if e.config.Username != "" {
+		var auth smtp.Auth = smtp.PlainAuth("", e.config.Username, e.config.Password, e.config.Host)
+		if e.config.TLS == emailTLSNone {
+			auth = &plaintextAuth{Auth: auth}
+		}
+		if err = client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(e.config.From); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err = client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = writer.Write(message); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// plaintextAuth lets PLAIN authentication go over a connection without TLS,
+// which smtp.PlainAuth refuses for anything but localhost. It's only used when
+// tls is none
+type plaintextAuth struct {
+	smtp.Auth
+}
+
+// Start implements smtp.Auth
+func (p *plaintextAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	unencrypted := *server
+	unencrypted.TLS = true
+
+	return p.Auth.Start(&unencrypted)
+}
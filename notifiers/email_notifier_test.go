@@ -0,0 +1,183 @@
+package notifiers
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+// smtpServer is a fake SMTP server that accepts a single session
+type smtpServer struct {
+	listener net.Listener
+	// commands are the commands sent by the client, except for the mail itself
+	commands []string
+	auth     string
+	data     string
+	done     chan struct{}
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &smtpServer{listener: listener, done: make(chan struct{})}
+	go server.serve()
+	t.Cleanup(func() { listener.Close() })
+
+	return server
+}
+
+func (s *smtpServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve() {
+	defer close(s.done)
+
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		s.commands = append(s.commands, line)
+
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO":
+			// no STARTTLS
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			s.auth = strings.TrimPrefix(line, "AUTH PLAIN ")
+			reply("235 OK")
+		case "MAIL", "RCPT":
+			reply("250 OK")
+		case "DATA":
+			reply("354 Go ahead")
+			data := &strings.Builder{}
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			s.data = data.String()
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
+
+func testReport() *utils.WorkflowReport {
+	exitCode := 2
+	return &utils.WorkflowReport{
+		Name:      "deploy",
+		SessionID: "session",
+		Status:    utils.StatusFailed,
+		Steps: []*utils.StepReport{
+			{
+				Name:     "build",
+				Status:   utils.StatusFailed,
+				ExitCode: &exitCode,
+				Runs: []*utils.RunReport{
+					{
+						Name:     "build",
+						Status:   utils.StatusFailed,
+						Error:    "exit status 2",
+						ExitCode: &exitCode,
+						Stdout:   []string{"compiling", "linking"},
+						Stderr:   []string{"undefined: main"},
+						Output:   []string{"compiling", "undefined: main", "linking"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestEmailWithoutTLS(t *testing.T) {
+	server := newSMTPServer(t)
+	notifier, err := NewEmailNotifier(&EmailConfig{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		TLS:      emailTLSNone,
+		Username: "trackman",
+		Password: "secret",
+		From:     "trackman@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipients := []string{"ops@example.com"}
+	if err = notifier.send(recipients, notifier.message(testReport(), recipients)); err != nil {
+		t.Fatal(err)
+	}
+	<-server.done
+
+	if auth, _ := base64.StdEncoding.DecodeString(server.auth); string(auth) != "\x00trackman\x00secret" {
+		t.Errorf("unexpected credentials %q", auth)
+	}
+	if !strings.Contains(server.data, "Subject: Trackman: deploy failed (session)\r\n") {
+		t.Errorf("unexpected subject in\n%s", server.data)
+	}
+	// the output of the failed run is in the order it was written
+	if !strings.Contains(server.data, "  compiling\r\n  undefined: main\r\n  linking\r\n") {
+		t.Errorf("unexpected output in\n%s", server.data)
+	}
+}
+
+func TestEmailRequiresStartTLS(t *testing.T) {
+	server := newSMTPServer(t)
+	notifier, err := NewEmailNotifier(&EmailConfig{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Username: "trackman",
+		Password: "secret",
+		From:     "trackman@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recipients := []string{"ops@example.com"}
+	if err = notifier.send(recipients, notifier.message(testReport(), recipients)); err == nil {
+		t.Fatal("expected an error for a server without STARTTLS")
+	}
+	<-server.done
+
+	for _, command := range server.commands {
+		if strings.HasPrefix(command, "AUTH") || strings.HasPrefix(command, "MAIL") {
+			t.Errorf("sent %s without TLS", command)
+		}
+	}
+}
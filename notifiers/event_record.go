@@ -0,0 +1,110 @@
+package notifiers
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+// EventRecord is an event as it's sent out of Trackman: written to the event
+// stream, passed to exec notifiers and posted by webhooks. Its JSON is the
+// trackman.event/v1 schema, so fields can be added but never changed or removed
+type EventRecord struct {
+	Schema  string             `json:"schema"`
+	Name    string             `json:"name"`
+	Payload EventRecordPayload `json:"payload"`
+}
+
+// EventRecordPayload holds the details of an EventRecord. Fields without a
+// value are omitted
+type EventRecordPayload struct {
+	EventUUID   string              `json:"event_uuid"`
+	SessionID   string              `json:"session_id"`
+	Timestamp   time.Time           `json:"timestamp"`
+	Workflow    string              `json:"workflow"`
+	Step        string              `json:"step,omitempty"`
+	Spinner     *EventRecordSpinner `json:"spinner,omitempty"`
+	StartedAt   *time.Time          `json:"started_at,omitempty"`
+	EndedAt     *time.Time          `json:"ended_at,omitempty"`
+	DurationMs  *int64              `json:"duration_ms,omitempty"`
+	QueueWaitMs *int64              `json:"queue_wait_ms,omitempty"`
+	ExitCode    *int                `json:"exit_code,omitempty"`
+	Signal      string              `json:"signal,omitempty"`
+	TimedOut    bool                `json:"timed_out,omitempty"`
+	Attempt     int                 `json:"attempt,omitempty"`
+	Stdout      []string            `json:"stdout,omitempty"`
+	Stderr      []string            `json:"stderr,omitempty"`
+	Output      []string            `json:"output,omitempty"`
+	OutputFile  string              `json:"output_file,omitempty"`
+	Error       string              `json:"error,omitempty"`
+	Confirmed   *bool               `json:"confirmed,omitempty"`
+}
+
+// EventRecordSpinner is the process an event belongs to
+type EventRecordSpinner struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// NewEventRecord builds the record of an event
+func NewEventRecord(event *utils.Event) *EventRecord {
+	payload := event.Payload
+
+	record := &EventRecord{
+		Schema: EventStreamSchema,
+		Name:   event.Name,
+		Payload: EventRecordPayload{
+			EventUUID:  payload.EventUUID,
+			SessionID:  payload.SessionID,
+			Timestamp:  payload.Timestamp,
+			Workflow:   payload.Workflow.Name,
+			Step:       payload.StepName,
+			StartedAt:  payload.StartedAt,
+			EndedAt:    payload.EndedAt,
+			ExitCode:   payload.ExitCode,
+			Signal:     payload.Signal,
+			TimedOut:   payload.TimedOut,
+			Attempt:    payload.Attempt,
+			Stdout:     payload.Stdout,
+			Stderr:     payload.Stderr,
+			Output:     payload.Output,
+			OutputFile: payload.OutputFile,
+			Error:      payload.Error,
+			Confirmed:  payload.Confirmed,
+		},
+	}
+
+	if payload.Spinner != nil {
+		record.Payload.Spinner = &EventRecordSpinner{
+			UUID: payload.Spinner.UUID,
+			Name: payload.Spinner.Name,
+			Kind: payload.Spinner.Kind,
+		}
+	}
+	if payload.StartedAt != nil {
+		record.Payload.DurationMs = milliseconds(payload.Duration)
+	}
+	if event.Name == utils.EventStepStarted {
+		record.Payload.QueueWaitMs = milliseconds(payload.QueueWait)
+	}
+
+	return record
+}
+
+// MarshalEvent returns the JSON of an event as it's written to the event
+// stream with the secrets of the workflow masked
+func MarshalEvent(event *utils.Event) ([]byte, error) {
+	line, err := json.Marshal(NewEventRecord(event))
+	if err != nil {
+		return nil, err
+	}
+
+	return event.Payload.Workflow.Masker().MaskBytes(line), nil
+}
+
+func milliseconds(duration time.Duration) *int64 {
+	value := int64(duration / time.Millisecond)
+	return &value
+}
@@ -0,0 +1,68 @@
+package notifiers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+func TestMarshalEvent(t *testing.T) {
+	startedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	endedAt := startedAt.Add(1500 * time.Millisecond)
+	exitCode := 1
+
+	event := &utils.Event{
+		Name: utils.EventRunFail,
+		Payload: utils.Payload{
+			EventUUID: "uuid",
+			SessionID: "session",
+			Timestamp: endedAt,
+			Workflow:  &utils.Workflow{Name: "deploy"},
+			Step:      &utils.Step{Name: "build", Command: "make build"},
+			StepName:  "build",
+			StartedAt: &startedAt,
+			EndedAt:   &endedAt,
+			Duration:  endedAt.Sub(startedAt),
+			ExitCode:  &exitCode,
+		},
+	}
+
+	line, err := MarshalEvent(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(line), "make build") {
+		t.Errorf("the step command shouldn't be in the event: %s", line)
+	}
+
+	var record map[string]interface{}
+	if err = json.Unmarshal(line, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["schema"] != EventStreamSchema || record["name"] != utils.EventRunFail {
+		t.Errorf("unexpected record %s", line)
+	}
+
+	payload := record["payload"].(map[string]interface{})
+	expected := map[string]interface{}{
+		"event_uuid":  "uuid",
+		"session_id":  "session",
+		"workflow":    "deploy",
+		"step":        "build",
+		"duration_ms": float64(1500),
+		"exit_code":   float64(1),
+	}
+	for key, value := range expected {
+		if payload[key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, payload[key])
+		}
+	}
+	for _, key := range []string{"spinner", "signal", "stdout", "error", "confirmed", "queue_wait_ms"} {
+		if _, ok := payload[key]; ok {
+			t.Errorf("expected %s to be omitted: %s", key, line)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package notifiers
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// EventStreamSchema is the schema version written with each line of the event stream
+	EventStreamSchema = "trackman.event/v1"
+
+	unixSocketPrefix = "unix://"
+)
+
+// EventStream writes every event as a line of JSON (NDJSON)
+type EventStream struct {
+	writer io.Writer
+	closer io.Closer
+	lock   sync.Mutex
+}
+
+// NewEventStream creates an EventStream for the given destination which can be
+// - (or stdout), a unix socket (unix:///path/to/socket) or a file name
+func NewEventStream(destination string) (*EventStream, error) {
+	if destination == "-" || destination == "stdout" {
+		return NewEventStreamForWriter(os.Stdout, nil), nil
+	}
+
+	if strings.HasPrefix(destination, unixSocketPrefix) {
+		conn, err := net.Dial("unix", strings.TrimPrefix(destination, unixSocketPrefix))
+		if err != nil {
+			return nil, err
+		}
+
+		return NewEventStreamForWriter(conn, conn), nil
+	}
+
+	file, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEventStreamForWriter(file, file), nil
+}
+
+// NewEventStreamForWriter creates an EventStream that writes to writer. closer
+// is closed when the stream is closed and can be nil
+func NewEventStreamForWriter(writer io.Writer, closer io.Closer) *EventStream {
+	return &EventStream{
+		writer: writer,
+		closer: closer,
+	}
+}
+
+// Notify implements Notifier
+func (e *EventStream) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	line, err := MarshalEvent(event)
+	if err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	_, err = e.writer.Write(append(line, '\n'))
+	return err
+}
+
+// Close implements Notifier
+func (e *EventStream) Close() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.closer == nil {
+		return nil
+	}
+
+	return e.closer.Close()
+}
@@ -0,0 +1,149 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/kballard/go-shellquote"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultExecTimeout = 30 * time.Second
+	execEnvPrefix      = "TRACKMAN_EVENT_"
+)
+
+// ExecConfig is the configuration of an ExecNotifier
+type ExecConfig struct {
+	Command   string        `mapstructure:"command"`
+	Workdir   string        `mapstructure:"workdir"`
+	Timeout   time.Duration `mapstructure:"timeout"`
+	QueueSize int           `mapstructure:"queue_size"`
+	Events    EventFilter   `mapstructure:"events"`
+}
+
+// ExecNotifier runs a command for each event. The event is passed to the command
+// as JSON on stdin and as TRACKMAN_EVENT_* environment variables
+type ExecNotifier struct {
+	config *ExecConfig
+	cmd    string
+	args   []string
+	queue  *asyncQueue
+}
+
+// NewExecNotifier creates a new ExecNotifier
+func NewExecNotifier(config *ExecConfig) (*ExecNotifier, error) {
+	parts, err := shellquote.Split(config.Command)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("no command for exec notifier")
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultExecTimeout
+	}
+
+	return &ExecNotifier{
+		config: config,
+		cmd:    parts[0],
+		args:   parts[1:],
+		queue:  newAsyncQueue(config.QueueSize),
+	}, nil
+}
+
+// Notify implements Notifier
+func (e *ExecNotifier) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	if !e.config.Events.Matches(event.Name) {
+		return nil
+	}
+
+	// build the input now since the event can change once we return
+	input, err := MarshalEvent(event)
+	if err != nil {
+		return err
+	}
+	env := append(os.Environ(), eventEnv(event)...)
+
+	queued := e.queue.push(func() {
+		output, err := e.run(input, env)
+		entry := logger.WithField("Event", event.Name)
+		if len(output) > 0 {
+			entry.Debug(string(output))
+		}
+		if err != nil {
+			entry.Errorf("Failed to run notifier %s: %s", e.config.Command, err)
+		}
+	})
+	if !queued {
+		logger.WithField("Event", event.Name).Warnf("Queue for notifier %s is full. Dropping event", e.config.Command)
+	}
+
+	return nil
+}
+
+// Close implements Notifier
+func (e *ExecNotifier) Close() error {
+	return e.queue.close(defaultCloseTimeout)
+}
+
+func (e *ExecNotifier) run(input []byte, env []string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.config.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.cmd, e.args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = env
+	cmd.Dir = e.config.Workdir
+
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return output, fmt.Errorf("timed out after %s", e.config.Timeout)
+	}
+
+	return output, err
+}
+
+func eventEnv(event *utils.Event) []string {
+	payload := event.Payload
+	values := map[string]string{
+		"NAME":       event.Name,
+		"WORKFLOW":   payload.Workflow.Name,
+		"UUID":       payload.EventUUID,
+		"SESSION_ID": payload.SessionID,
+		"TIMESTAMP":  payload.Timestamp.Format(time.RFC3339Nano),
+		"ERROR":      payload.Error,
+		"SIGNAL":     payload.Signal,
+		"TIMED_OUT":  strconv.FormatBool(payload.TimedOut),
+	}
+	if payload.StepName != "" {
+		values["STEP"] = payload.StepName
+	}
+	if payload.Spinner != nil {
+		values["SPINNER"] = payload.Spinner.Name
+		values["KIND"] = payload.Spinner.Kind
+	}
+	if payload.ExitCode != nil {
+		values["EXIT_CODE"] = strconv.Itoa(*payload.ExitCode)
+	}
+	if payload.Duration != 0 {
+		values["DURATION"] = payload.Duration.String()
+	}
+	if payload.Attempt != 0 {
+		values["ATTEMPT"] = strconv.Itoa(payload.Attempt)
+	}
+
+	env := make([]string, 0, len(values))
+	for key, value := range values {
+		env = append(env, execEnvPrefix+key+"="+value)
+	}
+
+	return env
+}
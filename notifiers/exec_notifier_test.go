@@ -0,0 +1,141 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// runExec sends the events to an exec notifier running command in a
+// temporary directory and returns the directory and the logs
+func runExec(t *testing.T, config *ExecConfig, names ...string) (string, string) {
+	t.Helper()
+
+	config.Workdir = t.TempDir()
+	notifier, err := NewExecNotifier(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.Out = logs
+	logger.Level = logrus.DebugLevel
+	for _, name := range names {
+		if err = notifier.Notify(context.Background(), logger, testEvent(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// waits for the commands to finish
+	if err = notifier.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return config.Workdir, logs.String()
+}
+
+func readFile(t *testing.T, dir string, name string) string {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestExec(t *testing.T) {
+	dir, logs := runExec(t, &ExecConfig{
+		Command: `sh -c 'cat > event.json; env | grep ^TRACKMAN_EVENT_ | sort > env.txt; echo done'`,
+	}, utils.EventRunFail)
+
+	var record EventRecord
+	if err := json.Unmarshal([]byte(readFile(t, dir, "event.json")), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Name != utils.EventRunFail || record.Payload.Workflow != "deploy" || record.Payload.Step != "build" || record.Payload.Error != "exit status 1" {
+		t.Errorf("unexpected event on stdin %+v", record)
+	}
+
+	env := readFile(t, dir, "env.txt")
+	for _, expected := range []string{
+		"TRACKMAN_EVENT_NAME=run.fail",
+		"TRACKMAN_EVENT_WORKFLOW=deploy",
+		"TRACKMAN_EVENT_STEP=build",
+		"TRACKMAN_EVENT_UUID=uuid",
+		"TRACKMAN_EVENT_SESSION_ID=session",
+		"TRACKMAN_EVENT_ERROR=exit status 1",
+		"TRACKMAN_EVENT_TIMED_OUT=false",
+	} {
+		if !strings.Contains(env, expected+"\n") {
+			t.Errorf("expected %s in\n%s", expected, env)
+		}
+	}
+	// there is no exit code in the event
+	if strings.Contains(env, "TRACKMAN_EVENT_EXIT_CODE") {
+		t.Errorf("unexpected exit code in\n%s", env)
+	}
+
+	// the output of the command is logged at the debug level
+	if !strings.Contains(logs, "done") {
+		t.Errorf("expected the output of the command in the logs\n%s", logs)
+	}
+}
+
+func TestExecFilter(t *testing.T) {
+	dir, _ := runExec(t, &ExecConfig{
+		Command: `sh -c 'echo $TRACKMAN_EVENT_NAME >> events.txt'`,
+		Events:  EventFilter{"run.*", "workflow.failed"},
+	}, utils.EventStepStarted, utils.EventRunFail, utils.EventWorkflowFinished, utils.EventWorkflowFailed)
+
+	if events := readFile(t, dir, "events.txt"); events != "run.fail\nworkflow.failed\n" {
+		t.Errorf("unexpected events\n%s", events)
+	}
+}
+
+func TestExecQueueFull(t *testing.T) {
+	dir, logs := runExec(t, &ExecConfig{
+		Command:   `sh -c 'echo $TRACKMAN_EVENT_NAME >> events.txt; sleep 0.2'`,
+		QueueSize: 1,
+	}, utils.EventRunFail, utils.EventRunFail, utils.EventRunFail)
+
+	// at most one event waits while the command runs
+	if events := strings.Count(readFile(t, dir, "events.txt"), "\n"); events < 1 || events > 2 {
+		t.Errorf("expected 1 or 2 events to be sent, got %d", events)
+	}
+	if !strings.Contains(logs, "is full. Dropping event") {
+		t.Errorf("expected dropped events to be logged\n%s", logs)
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	started := time.Now()
+	_, logs := runExec(t, &ExecConfig{
+		Command: "sleep 5",
+		Timeout: 50 * time.Millisecond,
+	}, utils.EventRunFail)
+
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("expected the command to be stopped, took %s", elapsed)
+	}
+	if !strings.Contains(logs, "timed out after 50ms") {
+		t.Errorf("expected the timeout to be logged\n%s", logs)
+	}
+}
+
+func TestExecInvalidCommand(t *testing.T) {
+	for _, command := range []string{"", "sh -c 'unterminated"} {
+		if _, err := NewExecNotifier(&ExecConfig{Command: command}); err == nil {
+			t.Errorf("expected an error for %q", command)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package notifiers
+
+import "path"
+
+// EventFilter is a list of event name patterns (like run.fail or step.*).
+// An empty filter matches all events
+type EventFilter []string
+
+// Matches returns true if the event name matches any of the patterns in the filter
+func (f EventFilter) Matches(name string) bool {
+	if len(f) == 0 {
+		return true
+	}
+
+	for _, pattern := range f {
+		if matched, err := path.Match(pattern, name); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
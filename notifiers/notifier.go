@@ -0,0 +1,69 @@
+package notifiers
+
+import (
+	"context"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+)
+
+// Notifier receives workflow events and is closed once the run is over
+type Notifier interface {
+	Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error
+	Close() error
+}
+
+// NotifierFunc turns a plain notification function (like ConsoleNotify) into a Notifier
+type NotifierFunc func(ctx context.Context, logger *logrus.Logger, event *utils.Event) error
+
+// Notify implements Notifier
+func (f NotifierFunc) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	return f(ctx, logger, event)
+}
+
+// Close implements Notifier
+func (f NotifierFunc) Close() error {
+	return nil
+}
+
+// Hub sends each event to all of its notifiers
+type Hub struct {
+	notifiers []Notifier
+}
+
+// NewHub creates a new Hub with the given notifiers
+func NewHub(notifiers ...Notifier) *Hub {
+	return &Hub{
+		notifiers: notifiers,
+	}
+}
+
+// Add adds a notifier to the hub
+func (h *Hub) Add(notifier Notifier) {
+	h.notifiers = append(h.notifiers, notifier)
+}
+
+// Notify sends the event to all notifiers. It can be used as WorkflowOptions.Notifier
+func (h *Hub) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	var errors error
+	for _, notifier := range h.notifiers {
+		if err := notifier.Notify(ctx, logger, event); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+	}
+
+	return errors
+}
+
+// Close closes all notifiers
+func (h *Hub) Close() error {
+	var errors error
+	for _, notifier := range h.notifiers {
+		if err := notifier.Close(); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+	}
+
+	return errors
+}
@@ -0,0 +1,63 @@
+package notifiers
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	defaultQueueSize    = 100
+	defaultCloseTimeout = 30 * time.Second
+)
+
+// asyncQueue runs jobs one by one in the background so notifiers that talk to
+// the outside world never block the step that raised the event
+type asyncQueue struct {
+	jobs chan func()
+	done chan struct{}
+}
+
+func newAsyncQueue(size int) *asyncQueue {
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+
+	q := &asyncQueue{
+		jobs: make(chan func(), size),
+		done: make(chan struct{}),
+	}
+
+	go q.work()
+
+	return q
+}
+
+func (q *asyncQueue) work() {
+	defer close(q.done)
+
+	for job := range q.jobs {
+		job()
+	}
+}
+
+// push adds a job to the queue. It returns false without blocking if the queue is full
+func (q *asyncQueue) push(job func()) bool {
+	select {
+	case q.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops accepting new jobs and waits for the pending ones to finish
+func (q *asyncQueue) close(timeout time.Duration) error {
+	close(q.jobs)
+
+	select {
+	case <-q.done:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("timed out waiting for pending notifications")
+	}
+}
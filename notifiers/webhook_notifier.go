@@ -0,0 +1,240 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// WebhookSignatureHeader holds the HMAC signature of the body when a secret is set
+	WebhookSignatureHeader = "X-Trackman-Signature"
+
+	defaultWebhookTimeout = 10 * time.Second
+	defaultWebhookBackoff = time.Second
+)
+
+// WebhookConfig is the configuration of a WebhookNotifier
+type WebhookConfig struct {
+	URL       string            `mapstructure:"url"`
+	Method    string            `mapstructure:"method"`
+	Headers   map[string]string `mapstructure:"headers"`
+	Templates map[string]string `mapstructure:"templates"`
+	Secret    string            `mapstructure:"secret"`
+	Timeout   time.Duration     `mapstructure:"timeout"`
+	Retries   int               `mapstructure:"retries"`
+	Backoff   time.Duration     `mapstructure:"backoff"`
+	QueueSize int               `mapstructure:"queue_size"`
+	Events    EventFilter       `mapstructure:"events"`
+}
+
+// WebhookNotifier sends events to an HTTP endpoint
+type WebhookNotifier struct {
+	config    *WebhookConfig
+	client    *http.Client
+	templates []*webhookTemplate
+	queue     *asyncQueue
+	// stop is closed when the notifier is closed to give up on retries
+	stop chan struct{}
+}
+
+// webhookTemplate is the template for the events matching pattern
+type webhookTemplate struct {
+	pattern  string
+	template *template.Template
+}
+
+// NewWebhookNotifier creates a new WebhookNotifier. Templates are keyed by event name
+// (or pattern) and rendered with the event. Events without a template are sent as JSON
+func NewWebhookNotifier(config *WebhookConfig) (*WebhookNotifier, error) {
+	if config.URL == "" {
+		return nil, errors.New("no url for webhook notifier")
+	}
+	if config.Method == "" {
+		config.Method = http.MethodPost
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultWebhookTimeout
+	}
+	if config.Backoff == 0 {
+		config.Backoff = defaultWebhookBackoff
+	}
+
+	templates := make([]*webhookTemplate, 0, len(config.Templates))
+	for name, body := range config.Templates {
+		tmpl, err := template.New(name).Funcs(template.FuncMap{"json": toJSON}).Parse(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook template for %s: %s", name, err)
+		}
+
+		templates = append(templates, &webhookTemplate{pattern: name, template: tmpl})
+	}
+	// so the same template is picked for an event every time
+	sort.Slice(templates, func(i, j int) bool {
+		if len(templates[i].pattern) != len(templates[j].pattern) {
+			return len(templates[i].pattern) > len(templates[j].pattern)
+		}
+		return templates[i].pattern < templates[j].pattern
+	})
+
+	return &WebhookNotifier{
+		config:    config,
+		client:    &http.Client{Timeout: config.Timeout},
+		templates: templates,
+		queue:     newAsyncQueue(config.QueueSize),
+		stop:      make(chan struct{}),
+	}, nil
+}
+
+// Notify implements Notifier
+func (w *WebhookNotifier) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	if !w.config.Events.Matches(event.Name) {
+		return nil
+	}
+
+	// render now since the event can change once we return
+	body, err := w.render(event)
+	if err != nil {
+		return err
+	}
+
+	queued := w.queue.push(func() {
+		if err := w.send(body); err != nil {
+			logger.WithField("Event", event.Name).Errorf("Failed to send webhook to %s: %s", w.config.URL, err)
+		}
+	})
+	if !queued {
+		logger.WithField("Event", event.Name).Warnf("Webhook queue for %s is full. Dropping event", w.config.URL)
+	}
+
+	return nil
+}
+
+// Close implements Notifier. Pending events are still sent, but failed
+// requests are not retried anymore
+func (w *WebhookNotifier) Close() error {
+	close(w.stop)
+	return w.queue.close(defaultCloseTimeout)
+}
+
+func (w *WebhookNotifier) render(event *utils.Event) ([]byte, error) {
+	tmpl := w.findTemplate(event.Name)
+	if tmpl == nil {
+		return MarshalEvent(event)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, NewEventRecord(event)); err != nil {
+		return nil, err
+	}
+
+	return event.Payload.Workflow.Masker().MaskBytes(buf.Bytes()), nil
+}
+
+// findTemplate returns the template for an event name. A template for the
+// name wins over patterns, and longer patterns win over shorter ones
+func (w *WebhookNotifier) findTemplate(name string) *template.Template {
+	for _, tmpl := range w.templates {
+		if tmpl.pattern == name {
+			return tmpl.template
+		}
+	}
+
+	for _, tmpl := range w.templates {
+		if EventFilter([]string{tmpl.pattern}).Matches(name) {
+			return tmpl.template
+		}
+	}
+
+	return nil
+}
+
+func (w *WebhookNotifier) send(body []byte) error {
+	var err error
+	for attempt := 0; attempt <= w.config.Retries; attempt++ {
+		if attempt > 0 && !w.wait(w.config.Backoff*time.Duration(1<<uint(attempt-1))) {
+			return err
+		}
+
+		var retry bool
+		if retry, err = w.post(body); err == nil || !retry {
+			return err
+		}
+	}
+
+	return err
+}
+
+// wait waits before a retry. It returns false if the notifier was closed in the meantime
+func (w *WebhookNotifier) wait(backoff time.Duration) bool {
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-w.stop:
+		return false
+	}
+}
+
+// post sends the body once and returns true if the failure is worth a retry
+func (w *WebhookNotifier) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(w.config.Method, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range w.config.Headers {
+		req.Header.Set(key, value)
+	}
+	if w.config.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+sign(w.config.Secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	// the connection is only reused once the body is read
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("webhook returned %s", resp.Status)
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func toJSON(value interface{}) (string, error) {
+	buff, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(buff), nil
+}
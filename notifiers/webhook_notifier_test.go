@@ -0,0 +1,230 @@
+package notifiers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// webhookServer records the requests it gets and replies with the given status codes in order.
+// Once they run out, it replies with 200
+type webhookServer struct {
+	*httptest.Server
+	statuses  []int
+	requests  []*http.Request
+	bodies    []string
+	signature []string
+	lock      sync.Mutex
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) *webhookServer {
+	server := &webhookServer{statuses: statuses}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		server.lock.Lock()
+		defer server.lock.Unlock()
+
+		server.requests = append(server.requests, r)
+		server.bodies = append(server.bodies, string(body))
+		server.signature = append(server.signature, r.Header.Get(WebhookSignatureHeader))
+
+		status := http.StatusOK
+		if len(server.statuses) > 0 {
+			status, server.statuses = server.statuses[0], server.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func (s *webhookServer) count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.requests)
+}
+
+func testEvent(name string) *utils.Event {
+	return &utils.Event{
+		Name: name,
+		Payload: utils.Payload{
+			EventUUID: "uuid",
+			SessionID: "session",
+			Timestamp: time.Now(),
+			Workflow:  &utils.Workflow{Name: "deploy"},
+			StepName:  "build",
+			Error:     "exit status 1",
+		},
+	}
+}
+
+func notify(t *testing.T, notifier *WebhookNotifier, names ...string) {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	for _, name := range names {
+		if err := notifier.Notify(context.Background(), logger, testEvent(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	server := newWebhookServer(t)
+	notifier, err := NewWebhookNotifier(&WebhookConfig{
+		URL:       server.URL,
+		Headers:   map[string]string{"X-Team": "ops"},
+		Templates: map[string]string{"run.*": `{"text": "{{ .Payload.Step }} failed: {{ .Payload.Error }}"}`},
+		Secret:    "secret",
+		Events:    EventFilter{"run.fail", "workflow.*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notify(t, notifier, utils.EventStepStarted, utils.EventRunFail, utils.EventWorkflowFailed)
+	if err = notifier.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// step.started is filtered out
+	if server.count() != 2 {
+		t.Fatalf("expected 2 requests, got %d", server.count())
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	if body := server.bodies[0]; body != `{"text": "build failed: exit status 1"}` {
+		t.Errorf("unexpected templated body %s", body)
+	}
+	if header := server.requests[0].Header.Get("X-Team"); header != "ops" {
+		t.Errorf("expected the X-Team header, got %q", header)
+	}
+
+	var record EventRecord
+	if err = json.Unmarshal([]byte(server.bodies[1]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Name != utils.EventWorkflowFailed || record.Payload.Workflow != "deploy" {
+		t.Errorf("unexpected event %s", server.bodies[1])
+	}
+
+	for idx, body := range server.bodies {
+		if expected := "sha256=" + sign("secret", []byte(body)); server.signature[idx] != expected {
+			t.Errorf("expected signature %s, got %s", expected, server.signature[idx])
+		}
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	server := newWebhookServer(t, http.StatusBadGateway, http.StatusTooManyRequests, http.StatusOK, http.StatusBadRequest)
+	notifier, err := NewWebhookNotifier(&WebhookConfig{
+		URL:     server.URL,
+		Retries: 3,
+		Backoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first event goes through on the third attempt. The second one isn't
+	// retried since 400 is not worth a retry
+	notify(t, notifier, utils.EventRunFail, utils.EventRunFail)
+	// retries are dropped once the notifier is closed
+	deadline := time.Now().Add(5 * time.Second)
+	for server.count() < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err = notifier.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if server.count() != 4 {
+		t.Errorf("expected 4 requests, got %d", server.count())
+	}
+}
+
+func TestWebhookCloseStopsRetries(t *testing.T) {
+	server := newWebhookServer(t, http.StatusServiceUnavailable)
+	notifier, err := NewWebhookNotifier(&WebhookConfig{
+		URL:     server.URL,
+		Retries: 1,
+		Backoff: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notify(t, notifier, utils.EventRunFail)
+	for server.count() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	closed := make(chan error)
+	go func() { closed <- notifier.Close() }()
+
+	select {
+	case err = <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited for the retry")
+	}
+
+	if server.count() != 1 {
+		t.Errorf("expected 1 request, got %d", server.count())
+	}
+}
+
+func TestWebhookTemplatePriority(t *testing.T) {
+	notifier, err := NewWebhookNotifier(&WebhookConfig{
+		URL: "http://localhost",
+		Templates: map[string]string{
+			"*":        "any",
+			"run.*":    "run",
+			"run.fail": "fail",
+			"ru*":      "short",
+			"step.*":   "step",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		event    string
+		expected string
+	}{
+		{utils.EventRunFail, "fail"},
+		{utils.EventRunSuccess, "run"},
+		{utils.EventStepStarted, "step"},
+		{utils.EventWorkflowStarted, "any"},
+	}
+
+	for _, test := range tests {
+		// the same template is used every time
+		for idx := 0; idx < 10; idx++ {
+			body, err := notifier.render(testEvent(test.event))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != test.expected {
+				t.Fatalf("expected template %s for %s, got %s", test.expected, test.event, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package reports
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr,omitempty"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Body    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the workflow report as JUnit XML. Each step is a test case
+// and its preflight and probe runs are test cases nested under the step's class name
+func WriteJUnit(writer io.Writer, report *utils.WorkflowReport) error {
+	suite := junitTestSuite{
+		Name: report.Name,
+		Time: seconds(report.Duration),
+		Properties: []junitProperty{
+			{Name: "session_id", Value: report.SessionID},
+		},
+	}
+	if report.StartedAt != nil {
+		suite.Timestamp = report.StartedAt.Format(time.RFC3339)
+	}
+
+	for _, step := range report.Steps {
+		for _, run := range step.Preflights {
+			suite.Cases = append(suite.Cases, runTestCase(report, step, run))
+		}
+
+		suite.Cases = append(suite.Cases, stepTestCase(report, step))
+
+		for _, run := range step.Runs {
+			if run.Kind != utils.SpinnerKindStep {
+				suite.Cases = append(suite.Cases, runTestCase(report, step, run))
+			}
+		}
+	}
+
+	for _, testCase := range suite.Cases {
+		suite.Tests++
+		if testCase.Failure != nil {
+			suite.Failures++
+		}
+		if testCase.Skipped != nil {
+			suite.Skipped++
+		}
+	}
+
+	suites := junitTestSuites{
+		Name:     report.Name,
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	}
+
+	if _, err := io.WriteString(writer, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(writer)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(writer, "\n")
+	return err
+}
+
+func stepTestCase(report *utils.WorkflowReport, step *utils.StepReport) junitTestCase {
+	testCase := junitTestCase{
+		Name:      step.Name,
+		ClassName: report.Name,
+		Time:      seconds(step.Duration),
+	}
+
+	switch step.Status {
+	case utils.StatusFailed:
+		testCase.Failure = &junitMessage{
+			Message: failureMessage(step.Error, step.ExitCode),
+			Type:    utils.StatusFailed,
+		}
+	case utils.StatusSkipped, utils.StatusDisabled, utils.StatusCancelled, utils.StatusPending:
+		testCase.Skipped = &junitMessage{Message: step.Status}
+	}
+
+	// the step's own output
+	var body string
+	for _, run := range step.Runs {
+		if run.Kind == utils.SpinnerKindStep {
+			testCase.SystemOut, testCase.SystemErr, body = runOutput(run)
+		}
+	}
+	if testCase.Failure != nil {
+		testCase.Failure.Body = body
+	}
+
+	return testCase
+}
+
+func runTestCase(report *utils.WorkflowReport, step *utils.StepReport, run *utils.RunReport) junitTestCase {
+	testCase := junitTestCase{
+		Name:      run.Name,
+		ClassName: fmt.Sprintf("%s.%s", report.Name, step.Name),
+		Time:      seconds(run.Duration),
+	}
+	var body string
+	testCase.SystemOut, testCase.SystemErr, body = runOutput(run)
+
+	if run.Status == utils.StatusFailed {
+		testCase.Failure = &junitMessage{
+			Message: failureMessage(run.Error, run.ExitCode),
+			Type:    run.Kind,
+			Body:    body,
+		}
+	}
+
+	return testCase
+}
+
+// runOutput returns the stdout and stderr of a run and the body of its failure.
+// The report only has the last lines of each, so when the run kept its output
+// file all of the output is used instead, with stdout and stderr together as
+// they were written
+func runOutput(run *utils.RunReport) (string, string, string) {
+	if run.OutputFile != "" {
+		if output, err := ioutil.ReadFile(run.OutputFile); err == nil {
+			return string(output), "", string(output)
+		}
+	}
+
+	stderr := joinLines(run.Stderr)
+	return joinLines(run.Stdout), stderr, stderr
+}
+
+func failureMessage(message string, exitCode *int) string {
+	if exitCode == nil {
+		return message
+	}
+
+	return fmt.Sprintf("%s (exit code %d)", message, *exitCode)
+}
+
+func seconds(duration time.Duration) string {
+	return fmt.Sprintf("%.3f", duration.Seconds())
+}
+
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
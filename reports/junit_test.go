@@ -0,0 +1,39 @@
+package reports
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+func TestWriteJUnit(t *testing.T) {
+	report := testReport(t)
+	// a failed preflight keeps only the lines in the report
+	report.Steps[0].Preflights = append(report.Steps[0].Preflights, &utils.RunReport{
+		Name: "build.preflight", Kind: utils.SpinnerKindPreflight, Status: utils.StatusFailed, Attempt: 2, ExitCode: exitCode(2), Error: "exit status 2", Stderr: []string{"no credentials"},
+	})
+
+	buf := &strings.Builder{}
+	if err := WriteJUnit(buf, report); err != nil {
+		t.Fatal(err)
+	}
+
+	checkGolden(t, "junit.golden", buf.String())
+}
+
+func TestWriteJUnitMissingOutputFile(t *testing.T) {
+	report := testReport(t)
+	run := report.Steps[2].Runs[1]
+	run.OutputFile = run.OutputFile + ".removed"
+
+	buf := &strings.Builder{}
+	if err := WriteJUnit(buf, report); err != nil {
+		t.Fatal(err)
+	}
+
+	// falls back to the lines in the report
+	if strings.Contains(buf.String(), "TestCase12&#xA;") || !strings.Contains(buf.String(), "TestCase13&#xA;") {
+		t.Errorf("expected the last lines of the output\n%s", buf)
+	}
+}
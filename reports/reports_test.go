@@ -0,0 +1,127 @@
+package reports
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+var started = time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// at returns the time offset seconds after the start of the workflow
+func at(offset float64) *time.Time {
+	value := started.Add(time.Duration(offset * float64(time.Second)))
+	return &value
+}
+
+func exitCode(code int) *int {
+	return &code
+}
+
+// testReport returns the report of a workflow with a step in each status.
+// The test step kept its output in a file longer than the tail in the report
+func testReport(t *testing.T) *utils.WorkflowReport {
+	t.Helper()
+
+	var output []string
+	for idx := 1; idx <= 30; idx++ {
+		output = append(output, fmt.Sprintf("--- PASS: TestCase%d", idx))
+	}
+	output = append(output, "--- FAIL: TestDeploy <nil> & more", "FAIL")
+	outputFile := filepath.Join(t.TempDir(), "trackman-test")
+	if err := ioutil.WriteFile(outputFile, []byte(strings.Join(output, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return &utils.WorkflowReport{
+		Name:      "deploy",
+		SessionID: "session",
+		Status:    utils.StatusFailed,
+		StartedAt: at(0),
+		EndedAt:   at(62.5),
+		Duration:  62500 * time.Millisecond,
+		Error:     "step test failed",
+		Steps: []*utils.StepReport{
+			{
+				Name:      "build",
+				Status:    utils.StatusSuccess,
+				StartedAt: at(0),
+				EndedAt:   at(20),
+				Duration:  20 * time.Second,
+				Attempts:  1,
+				ExitCode:  exitCode(0),
+				Preflights: []*utils.RunReport{
+					{Name: "build.preflight", Kind: utils.SpinnerKindPreflight, Status: utils.StatusSuccess, Duration: 500 * time.Millisecond, Attempt: 1},
+				},
+				Runs: []*utils.RunReport{
+					{Name: "build", Kind: utils.SpinnerKindStep, Status: utils.StatusSuccess, Duration: 19 * time.Second, Attempt: 1, ExitCode: exitCode(0), Stdout: []string{"compiling", "done"}, Stderr: []string{"warning: unused variable"}},
+					{Name: "build.probe", Kind: utils.SpinnerKindProbe, Status: utils.StatusSuccess, Duration: 500 * time.Millisecond, Attempt: 1, Stdout: []string{"ok"}},
+				},
+			},
+			{
+				Name:      "lint",
+				Status:    utils.StatusSuccess,
+				StartedAt: at(0),
+				EndedAt:   at(5),
+				Duration:  5 * time.Second,
+				Attempts:  1,
+				ExitCode:  exitCode(0),
+			},
+			{
+				Name:      "test",
+				Status:    utils.StatusFailed,
+				DependsOn: []string{"build", "lint"},
+				StartedAt: at(20),
+				EndedAt:   at(62.5),
+				Duration:  42500 * time.Millisecond,
+				Attempts:  2,
+				ExitCode:  exitCode(1),
+				Error:     "exit status 1\nsee the output",
+				Runs: []*utils.RunReport{
+					{Name: "test", Kind: utils.SpinnerKindStep, Status: utils.StatusFailed, Duration: 20 * time.Second, Attempt: 1, ExitCode: exitCode(1), Error: "exit status 1", Stdout: []string{"flaky"}},
+					{Name: "test", Kind: utils.SpinnerKindStep, Status: utils.StatusFailed, Duration: 22 * time.Second, Attempt: 2, ExitCode: exitCode(1), Error: "exit status 1", Stdout: output[len(output)-utils.OutputTailSize:], Stderr: []string{"FAIL"}, OutputFile: outputFile},
+				},
+			},
+			{
+				Name:      "publish",
+				Status:    utils.StatusCancelled,
+				DependsOn: []string{"test"},
+			},
+			{
+				Name:   "docs",
+				Status: utils.StatusDisabled,
+			},
+			{
+				Name:   "notify",
+				Status: utils.StatusSkipped,
+			},
+		},
+	}
+}
+
+func checkGolden(t *testing.T, name string, actual string) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, []byte(actual), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(expected) != actual {
+		t.Errorf("%s doesn't match. Run go test -update if the change is expected\nexpected:\n%s\ngot:\n%s", path, expected, actual)
+	}
+}
@@ -0,0 +1,217 @@
+package reports
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+const (
+	// SummaryJSON is the JSON summary format
+	SummaryJSON = "json"
+	// SummaryMarkdown is the Markdown summary format
+	SummaryMarkdown = "markdown"
+)
+
+// Summary is a workflow report with totals and the critical path of the run
+type Summary struct {
+	*utils.WorkflowReport
+	Totals               Totals        `json:"totals"`
+	CriticalPath         []string      `json:"critical_path"`
+	CriticalPathDuration time.Duration `json:"critical_path_duration"`
+}
+
+// Totals holds the number of steps in each status
+type Totals struct {
+	Steps     int `json:"steps"`
+	Success   int `json:"success"`
+	Failed    int `json:"failed"`
+	Skipped   int `json:"skipped"`
+	Disabled  int `json:"disabled"`
+	Cancelled int `json:"cancelled"`
+	Attempts  int `json:"attempts"`
+}
+
+// NewSummary creates a summary for the workflow report
+func NewSummary(report *utils.WorkflowReport) *Summary {
+	summary := &Summary{
+		WorkflowReport: report,
+	}
+
+	for _, step := range report.Steps {
+		summary.Totals.Steps++
+		summary.Totals.Attempts += step.Attempts
+
+		switch step.Status {
+		case utils.StatusSuccess:
+			summary.Totals.Success++
+		case utils.StatusFailed:
+			summary.Totals.Failed++
+		case utils.StatusSkipped:
+			summary.Totals.Skipped++
+		case utils.StatusDisabled:
+			summary.Totals.Disabled++
+		case utils.StatusCancelled:
+			summary.Totals.Cancelled++
+		}
+	}
+
+	summary.CriticalPath, summary.CriticalPathDuration = criticalPath(report)
+
+	return summary
+}
+
+// CheckSummaryFormat returns an error if the summary format is not supported
+func CheckSummaryFormat(format string) error {
+	if format != SummaryJSON && format != SummaryMarkdown {
+		return fmt.Errorf("invalid summary format %s. Valid values are %s and %s", format, SummaryJSON, SummaryMarkdown)
+	}
+
+	return nil
+}
+
+// WriteSummary writes the summary of the workflow report in the given format
+func WriteSummary(writer io.Writer, format string, report *utils.WorkflowReport) error {
+	if err := CheckSummaryFormat(format); err != nil {
+		return err
+	}
+
+	if format == SummaryJSON {
+		return writeSummaryJSON(writer, NewSummary(report))
+	}
+
+	return writeSummaryMarkdown(writer, NewSummary(report))
+}
+
+func writeSummaryJSON(writer io.Writer, summary *Summary) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(summary)
+}
+
+func writeSummaryMarkdown(writer io.Writer, summary *Summary) error {
+	buf := &strings.Builder{}
+
+	fmt.Fprintf(buf, "## %s: %s\n\n", summary.Name, summary.Status)
+	fmt.Fprintf(buf, "**Session ID:** `%s`  \n", summary.SessionID)
+	fmt.Fprintf(buf, "**Duration:** %s  \n", round(summary.Duration))
+	if summary.Error != "" {
+		fmt.Fprintf(buf, "**Error:** %s  \n", markdownCell(summary.Error))
+	}
+	buf.WriteString("\n")
+
+	buf.WriteString("| Step | Status | Attempts | Started | Ended | Duration | Exit Code | Error |\n")
+	buf.WriteString("|---|---|---|---|---|---|---|---|\n")
+	for _, step := range summary.Steps {
+		fmt.Fprintf(buf, "| %s | %s | %d | %s | %s | %s | %s | %s |\n",
+			markdownCell(step.Name),
+			step.Status,
+			step.Attempts,
+			formatTime(step.StartedAt),
+			formatTime(step.EndedAt),
+			round(step.Duration),
+			formatExitCode(step.ExitCode),
+			markdownCell(step.Error))
+	}
+	buf.WriteString("\n")
+
+	totals := summary.Totals
+	fmt.Fprintf(buf, "**Totals:** %d steps, %d succeeded, %d failed, %d skipped, %d disabled, %d cancelled  \n",
+		totals.Steps, totals.Success, totals.Failed, totals.Skipped, totals.Disabled, totals.Cancelled)
+	if len(summary.CriticalPath) > 0 {
+		fmt.Fprintf(buf, "**Critical Path:** %s (%s)  \n", strings.Join(summary.CriticalPath, " → "), round(summary.CriticalPathDuration))
+	}
+
+	_, err := io.WriteString(writer, buf.String())
+	return err
+}
+
+// criticalPath returns the chain of dependent steps that took the longest to run
+func criticalPath(report *utils.WorkflowReport) ([]string, time.Duration) {
+	steps := make(map[string]*utils.StepReport, len(report.Steps))
+	for _, step := range report.Steps {
+		steps[step.Name] = step
+	}
+
+	totals := make(map[string]time.Duration, len(steps))
+	previous := make(map[string]string, len(steps))
+
+	var total func(step *utils.StepReport) time.Duration
+	total = func(step *utils.StepReport) time.Duration {
+		if value, ok := totals[step.Name]; ok {
+			return value
+		}
+
+		// guard against circular dependencies
+		totals[step.Name] = step.Duration
+
+		var longest time.Duration
+		for _, name := range step.DependsOn {
+			dependency, ok := steps[name]
+			if !ok {
+				continue
+			}
+
+			if value := total(dependency); value > longest || previous[step.Name] == "" {
+				longest = value
+				previous[step.Name] = name
+			}
+		}
+
+		totals[step.Name] = step.Duration + longest
+		return totals[step.Name]
+	}
+
+	var last string
+	var longest time.Duration
+	for _, step := range report.Steps {
+		if step.StartedAt == nil {
+			continue
+		}
+
+		if value := total(step); value > longest || last == "" {
+			longest = value
+			last = step.Name
+		}
+	}
+
+	var path []string
+	seen := make(map[string]bool, len(steps))
+	for name := last; name != "" && !seen[name]; name = previous[name] {
+		seen[name] = true
+		path = append([]string{name}, path...)
+	}
+
+	return path, longest
+}
+
+func round(duration time.Duration) time.Duration {
+	return duration.Round(time.Millisecond)
+}
+
+func formatTime(value *time.Time) string {
+	if value == nil {
+		return ""
+	}
+
+	return value.Format(time.RFC3339)
+}
+
+func formatExitCode(exitCode *int) string {
+	if exitCode == nil {
+		return ""
+	}
+
+	return strconv.Itoa(*exitCode)
+}
+
+func markdownCell(value string) string {
+	value = strings.Replace(value, "|", "\\|", -1)
+	return strings.Join(strings.Fields(value), " ")
+}
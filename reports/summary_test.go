@@ -0,0 +1,107 @@
+package reports
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+func TestCriticalPath(t *testing.T) {
+	step := func(name string, seconds int, dependsOn ...string) *utils.StepReport {
+		return &utils.StepReport{Name: name, StartedAt: at(0), Duration: time.Duration(seconds) * time.Second, DependsOn: dependsOn}
+	}
+
+	tests := []struct {
+		name     string
+		steps    []*utils.StepReport
+		path     string
+		duration time.Duration
+	}{
+		{
+			name:     "longest chain",
+			steps:    testReport(t).Steps,
+			path:     "build,test",
+			duration: 62500 * time.Millisecond,
+		},
+		{
+			name:     "longer chain of shorter steps",
+			steps:    []*utils.StepReport{step("a", 10), step("b", 4), step("c", 4, "b"), step("d", 4, "c")},
+			path:     "b,c,d",
+			duration: 12 * time.Second,
+		},
+		{
+			name:     "single step",
+			steps:    []*utils.StepReport{step("a", 10), step("b", 4, "a"), step("c", 20)},
+			path:     "c",
+			duration: 20 * time.Second,
+		},
+		{
+			name:     "unknown dependency",
+			steps:    []*utils.StepReport{step("a", 10, "missing")},
+			path:     "a",
+			duration: 10 * time.Second,
+		},
+		{
+			name:  "nothing ran",
+			steps: []*utils.StepReport{{Name: "a", Status: utils.StatusSkipped}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, duration := criticalPath(&utils.WorkflowReport{Steps: test.steps})
+			if strings.Join(path, ",") != test.path || duration != test.duration {
+				t.Errorf("expected %s (%s), got %v (%s)", test.path, test.duration, path, duration)
+			}
+		})
+	}
+}
+
+func TestCriticalPathCircular(t *testing.T) {
+	steps := []*utils.StepReport{
+		{Name: "a", StartedAt: at(0), Duration: time.Second, DependsOn: []string{"b"}},
+		{Name: "b", StartedAt: at(0), Duration: time.Second, DependsOn: []string{"a"}},
+	}
+
+	// each step is only listed once
+	if path, _ := criticalPath(&utils.WorkflowReport{Steps: steps}); len(path) != 2 {
+		t.Errorf("unexpected path %v", path)
+	}
+}
+
+func TestNewSummary(t *testing.T) {
+	totals := NewSummary(testReport(t)).Totals
+	expected := Totals{Steps: 6, Success: 2, Failed: 1, Skipped: 1, Disabled: 1, Cancelled: 1, Attempts: 4}
+	if totals != expected {
+		t.Errorf("expected %+v, got %+v", expected, totals)
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	for _, format := range []string{SummaryJSON, SummaryMarkdown} {
+		t.Run(format, func(t *testing.T) {
+			report := testReport(t)
+			// the file name changes on every run
+			report.Steps[2].Runs[1].OutputFile = "/tmp/trackman-test"
+
+			buf := &strings.Builder{}
+			if err := WriteSummary(buf, format, report); err != nil {
+				t.Fatal(err)
+			}
+
+			checkGolden(t, "summary."+format+".golden", buf.String())
+		})
+	}
+}
+
+func TestWriteSummaryInvalidFormat(t *testing.T) {
+	buf := &strings.Builder{}
+	if err := WriteSummary(buf, "yaml", testReport(t)); err == nil || err.Error() != "invalid summary format yaml. Valid values are json and markdown" {
+		t.Errorf("unexpected error %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected output %s", buf)
+	}
+}
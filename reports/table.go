@@ -0,0 +1,82 @@
+package reports
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/fatih/color"
+)
+
+// WriteTable writes a colored table of all steps, their status, duration
+// and the first line of their error. Failed steps are listed first
+func WriteTable(writer io.Writer, report *utils.WorkflowReport) error {
+	steps := make([]*utils.StepReport, len(report.Steps))
+	copy(steps, report.Steps)
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].Status == utils.StatusFailed && steps[j].Status != utils.StatusFailed
+	})
+
+	rows := [][]string{{"STEP", "STATUS", "DURATION", "ERROR"}}
+	for _, step := range steps {
+		rows = append(rows, []string{step.Name, step.Status, round(step.Duration).String(), firstLine(step.Error)})
+	}
+
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for idx, cell := range row {
+			if width := utf8.RuneCountInString(cell); width > widths[idx] {
+				widths[idx] = width
+			}
+		}
+	}
+
+	buf := &strings.Builder{}
+	for idx, row := range rows {
+		for col, cell := range row {
+			// pad before coloring so the escape codes don't break the alignment
+			if col < len(row)-1 {
+				cell = fmt.Sprintf("%-*s", widths[col], cell)
+			}
+			if idx == 0 {
+				cell = color.New(color.Bold).Sprint(cell)
+			} else if col == 1 {
+				cell = statusColor(steps[idx-1].Status).Sprint(cell)
+			}
+
+			buf.WriteString(cell)
+			if col < len(row)-1 {
+				buf.WriteString("  ")
+			}
+		}
+		buf.WriteString("\n")
+	}
+
+	_, err := io.WriteString(writer, buf.String())
+	return err
+}
+
+func statusColor(status string) *color.Color {
+	switch status {
+	case utils.StatusSuccess:
+		return color.New(color.FgGreen)
+	case utils.StatusFailed:
+		return color.New(color.FgRed)
+	case utils.StatusRunning:
+		return color.New(color.FgCyan)
+	default:
+		return color.New(color.FgYellow)
+	}
+}
+
+func firstLine(value string) string {
+	value = strings.TrimSpace(value)
+	if idx := strings.Index(value, "\n"); idx >= 0 {
+		return value[:idx]
+	}
+
+	return value
+}
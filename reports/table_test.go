@@ -0,0 +1,33 @@
+package reports
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+func TestWriteTable(t *testing.T) {
+	noColor := color.NoColor
+	defer func() { color.NoColor = noColor }()
+
+	for _, colored := range []bool{false, true} {
+		color.NoColor = !colored
+
+		buf := &strings.Builder{}
+		if err := WriteTable(buf, testReport(t)); err != nil {
+			t.Fatal(err)
+		}
+
+		if colored {
+			// only the header and the status are colored
+			if !strings.Contains(buf.String(), "\x1b[31mfailed   \x1b[0m") || !strings.Contains(buf.String(), "\x1b[33mcancelled\x1b[0m") {
+				t.Errorf("expected colored statuses\n%q", buf)
+			}
+			continue
+		}
+
+		// failed steps come first, the rest keep their order
+		checkGolden(t, "table.golden", buf.String())
+	}
+}
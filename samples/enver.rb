@@ -1,2 +1,4 @@
 puts "FOO:" + ENV["FOO"]
-puts "HOME:" + ENV["HOME"]
\ No newline at end of file
+puts "HOME:" + ENV["HOME"]
+puts "STEP:" + ENV["TRACKMAN_STEP"].to_s
+puts "ATTEMPT:" + ENV["TRACKMAN_ATTEMPT"].to_s
\ No newline at end of file
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/trackman/notifiers"
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// RunQueued is used for runs waiting for a free slot
+	RunQueued = "queued"
+	// RunRunning is used for runs that are running
+	RunRunning = "running"
+	// RunSuccess is used for runs that finished without errors
+	RunSuccess = "success"
+	// RunFailed is used for runs that finished with errors
+	RunFailed = "failed"
+	// RunCancelled is used for runs that were cancelled
+	RunCancelled = "cancelled"
+
+	// subscriberBuffer is the number of events a slow subscriber can fall behind before it's dropped
+	subscriberBuffer = 100
+)
+
+// Run is a workflow submitted to the server
+type Run struct {
+	ID          string         `json:"id"`
+	SessionID   string         `json:"session_id"`
+	Name        string         `json:"name"`
+	Status      string         `json:"status"`
+	Metadata    utils.Metadata `json:"metadata,omitempty"`
+	SubmittedAt time.Time      `json:"submitted_at"`
+	StartedAt   *time.Time     `json:"started_at,omitempty"`
+	EndedAt     *time.Time     `json:"ended_at,omitempty"`
+	Error       string         `json:"error,omitempty"`
+
+	workflow    *utils.Workflow
+	hub         *notifiers.Hub
+	cancel      context.CancelFunc
+	events      [][]byte
+	subscribers map[chan []byte]struct{}
+	done        chan struct{}
+	lock        sync.Mutex
+}
+
+// runDetails is a run with the status of its steps
+type runDetails struct {
+	*Run
+	Report *utils.WorkflowReport `json:"report"`
+}
+
+// Notify implements notifiers.Notifier. It keeps the event for anyone
+// watching the run
+func (r *Run) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	line, err := notifiers.MarshalEvent(event)
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.events = append(r.events, line)
+	for subscriber := range r.subscribers {
+		select {
+		case subscriber <- line:
+		default:
+			// too slow to keep up
+			delete(r.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+
+	return nil
+}
+
+// Close implements notifiers.Notifier
+func (r *Run) Close() error {
+	return nil
+}
+
+// subscribe returns all the events of the run so far and a channel for the
+// ones to come. The channel is closed when the run is over
+func (r *Run) subscribe() ([][]byte, chan []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	past := make([][]byte, len(r.events))
+	copy(past, r.events)
+
+	subscriber := make(chan []byte, subscriberBuffer)
+	if r.isDone() {
+		close(subscriber)
+	} else {
+		r.subscribers[subscriber] = struct{}{}
+	}
+
+	return past, subscriber
+}
+
+func (r *Run) unsubscribe(subscriber chan []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.subscribers[subscriber]; ok {
+		delete(r.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// snapshot returns a copy of the run that is safe to serialize
+func (r *Run) snapshot() *Run {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return &Run{
+		ID:          r.ID,
+		SessionID:   r.SessionID,
+		Name:        r.Name,
+		Status:      r.Status,
+		Metadata:    r.Metadata,
+		SubmittedAt: r.SubmittedAt,
+		StartedAt:   r.StartedAt,
+		EndedAt:     r.EndedAt,
+		Error:       r.Error,
+	}
+}
+
+func (r *Run) details() *runDetails {
+	return &runDetails{
+		Run:    r.snapshot(),
+		Report: r.workflow.Report(),
+	}
+}
+
+// endedAt returns when the run finished or nil if it hasn't
+func (r *Run) endedAt() *time.Time {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.EndedAt
+}
+
+func (r *Run) setRunning() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	r.StartedAt = &now
+	r.Status = RunRunning
+}
+
+func (r *Run) finish(ctx context.Context, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	r.EndedAt = &now
+	switch {
+	case ctx.Err() != nil:
+		r.Status = RunCancelled
+	case err != nil:
+		r.Status = RunFailed
+	default:
+		r.Status = RunSuccess
+	}
+	if err != nil {
+		r.Error = r.workflow.Masker().Mask(err.Error())
+	}
+
+	for subscriber := range r.subscribers {
+		close(subscriber)
+	}
+	r.subscribers = map[chan []byte]struct{}{}
+	close(r.done)
+}
+
+// isDone should be called with the lock held
+func (r *Run) isDone() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
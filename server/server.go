@@ -0,0 +1,505 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/trackman/metrics"
+	"github.com/cloud66-oss/trackman/notifiers"
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	runsPath = "/runs"
+	// maxWorkflowSize is the largest workflow accepted by the server
+	maxWorkflowSize = 10 << 20
+	// defaultKeepRuns is the number of finished runs kept when KeepRuns isn't set
+	defaultKeepRuns = 100
+)
+
+// Options holds the settings of a Server
+type Options struct {
+	// Token is the bearer token required by all API calls. Empty means no auth
+	Token string
+	// MaxRuns is the number of workflows that can run at the same time. Others are queued
+	MaxRuns int
+	// Concurrency is the number of concurrent steps in each workflow
+	Concurrency int
+	// Timeout is the default timeout of the steps
+	Timeout time.Duration
+	// Yes answers yes to all steps with ask_to_proceed. Otherwise those steps are cancelled
+	Yes bool
+	// SecretKeyFile is the key used to decrypt encrypted secrets of the workflows
+	SecretKeyFile string
+	// Registry is served under /metrics. It is optional
+	Registry *metrics.Registry
+	// MetricsLabels are the metadata keys added as labels to the metrics
+	MetricsLabels []string
+	// Notifiers returns extra notifiers for each run. It is optional
+	Notifiers func() ([]notifiers.Notifier, error)
+	// KeepRuns is the number of finished runs kept with their events. Older
+	// ones are forgotten. It defaults to 100
+	KeepRuns int
+	// KeepRunsFor is how long finished runs are kept. Zero keeps them until
+	// there are more than KeepRuns
+	KeepRunsFor time.Duration
+}
+
+// Server runs workflows submitted over HTTP
+type Server struct {
+	options   *Options
+	logger    *logrus.Logger
+	collector *metrics.Collector
+	slots     chan struct{}
+	runs      map[string]*Run
+	order     []string
+	lock      sync.Mutex
+	mux       *http.ServeMux
+}
+
+// submission is the JSON body of a new run
+type submission struct {
+	Workflow json.RawMessage        `json:"workflow"`
+	Metadata utils.Metadata         `json:"metadata"`
+	Params   map[string]interface{} `json:"params"`
+}
+
+// NewServer creates a new Server
+func NewServer(options *Options, logger *logrus.Logger) *Server {
+	maxRuns := options.MaxRuns
+	if maxRuns < 1 {
+		maxRuns = 1
+	}
+
+	server := &Server{
+		options: options,
+		logger:  logger,
+		slots:   make(chan struct{}, maxRuns),
+		runs:    make(map[string]*Run),
+		mux:     http.NewServeMux(),
+	}
+
+	if options.Registry != nil {
+		server.collector = metrics.NewCollector(options.Registry, options.MetricsLabels, "")
+		server.mux.Handle("/metrics", server.authorize(options.Registry.Handler()))
+	}
+	server.mux.HandleFunc("/healthz", server.handleHealth)
+	server.mux.Handle(runsPath, server.authorize(http.HandlerFunc(server.handleRuns)))
+	server.mux.Handle(runsPath+"/", server.authorize(http.HandlerFunc(server.handleRun)))
+
+	return server
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Submit loads the workflow and queues it to run
+func (s *Server) Submit(ctx context.Context, buff []byte, metadata utils.Metadata, params map[string]interface{}) (*Run, error) {
+	run := &Run{
+		ID:          uuid.New().String(),
+		Status:      RunQueued,
+		Metadata:    metadata,
+		SubmittedAt: time.Now(),
+		subscribers: make(map[chan []byte]struct{}),
+		done:        make(chan struct{}),
+	}
+
+	hub := notifiers.NewHub(notifiers.NotifierFunc(notifiers.ConsoleNotify), run)
+	if s.collector != nil {
+		// the collector is shared between runs and shouldn't be closed with them
+		hub.Add(notifiers.NotifierFunc(s.collector.Notify))
+	}
+	if s.options.Notifiers != nil {
+		extra, err := s.options.Notifiers()
+		if err != nil {
+			return nil, err
+		}
+		for _, notifier := range extra {
+			hub.Add(notifier)
+		}
+	}
+
+	options := &utils.WorkflowOptions{
+		Notifier:       hub.Notify,
+		Concurrency:    s.options.Concurrency,
+		Timeout:        s.options.Timeout,
+		MetadataValues: metadata,
+		Params:         params,
+		Confirm:        s.confirm,
+		SecretKeyFile:  s.options.SecretKeyFile,
+	}
+
+	workflow, err := utils.LoadWorkflowFromBytes(ctx, options, buff)
+	if err != nil {
+		_ = hub.Close()
+		return nil, err
+	}
+	if workflow.Name == "" {
+		workflow.Name = "workflow"
+	}
+
+	run.workflow = workflow
+	// submitted metadata can hold secrets and runs are listed by the API
+	run.Metadata = workflow.Masker().MaskMetadata(metadata)
+	run.hub = hub
+	run.Name = workflow.Name
+	run.SessionID = workflow.SessionID()
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	run.cancel = cancel
+
+	s.lock.Lock()
+	s.prune()
+	s.runs[run.ID] = run
+	s.order = append(s.order, run.ID)
+	s.lock.Unlock()
+
+	go s.execute(runCtx, run)
+
+	return run, nil
+}
+
+// Find returns the run with the given ID or nil
+func (s *Server) Find(id string) *Run {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.prune()
+	return s.runs[id]
+}
+
+// Runs returns all runs in the order they were submitted
+func (s *Server) Runs() []*Run {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.prune()
+	runs := make([]*Run, 0, len(s.order))
+	for _, id := range s.order {
+		runs = append(runs, s.runs[id])
+	}
+
+	return runs
+}
+
+// Cancel stops a queued or running run
+func (s *Server) Cancel(id string) error {
+	run := s.Find(id)
+	if run == nil {
+		return fmt.Errorf("run %s not found", id)
+	}
+
+	run.cancel()
+
+	return nil
+}
+
+// Shutdown cancels all runs and waits for them to finish or for ctx to be done
+func (s *Server) Shutdown(ctx context.Context) error {
+	runs := s.Runs()
+	for _, run := range runs {
+		run.cancel()
+	}
+
+	for _, run := range runs {
+		select {
+		case <-run.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) execute(ctx context.Context, run *Run) {
+	defer run.cancel()
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		s.logger.WithField("Run", run.ID).Info("Cancelled before running")
+		run.finish(ctx, ctx.Err())
+		s.closeRun(run)
+		return
+	}
+
+	run.setRunning()
+	runErrors, stepErrors := run.workflow.Run(ctx)
+	if runErrors == nil {
+		runErrors = stepErrors
+	}
+
+	run.finish(ctx, runErrors)
+	s.closeRun(run)
+}
+
+func (s *Server) closeRun(run *Run) {
+	if err := run.hub.Close(); err != nil {
+		s.logger.WithField("Run", run.ID).Error(err)
+	}
+
+	s.lock.Lock()
+	s.prune()
+	s.lock.Unlock()
+}
+
+// prune forgets the finished runs older than KeepRunsFor and the ones beyond
+// the newest KeepRuns, so the server doesn't keep all runs, their events and
+// their output files forever. It should be called with the lock held
+func (s *Server) prune() {
+	keepRuns := s.options.KeepRuns
+	if keepRuns < 1 {
+		keepRuns = defaultKeepRuns
+	}
+
+	now := time.Now()
+	finished := 0
+	kept := make([]string, 0, len(s.order))
+	// newest first
+	for idx := len(s.order) - 1; idx >= 0; idx-- {
+		id := s.order[idx]
+		if endedAt := s.runs[id].endedAt(); endedAt != nil {
+			finished++
+			if finished > keepRuns || (s.options.KeepRunsFor > 0 && now.Sub(*endedAt) > s.options.KeepRunsFor) {
+				// nobody can get the report of the run anymore
+				if err := s.runs[id].workflow.RemoveOutputFiles(); err != nil {
+					s.logger.WithField("Run", id).Error(err)
+				}
+				delete(s.runs, id)
+				continue
+			}
+		}
+
+		kept = append(kept, id)
+	}
+
+	s.order = s.order[:0]
+	for idx := len(kept) - 1; idx >= 0; idx-- {
+		s.order = append(s.order, kept[idx])
+	}
+}
+
+func (s *Server) confirm(ctx context.Context, step *utils.Step) bool {
+	if !s.options.Yes {
+		s.logger.WithField(utils.FldStep, step.Name).Warn("Step needs confirmation which isn't possible over the API. Use --yes to run these steps")
+	}
+
+	return s.options.Yes
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.options.Token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.options.Token)) != 1 {
+				writeError(w, http.StatusUnauthorized, errors.New("invalid or missing token"))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleRuns handles /runs
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var runs []*Run
+		for _, run := range s.Runs() {
+			runs = append(runs, run.snapshot())
+		}
+		if runs == nil {
+			runs = []*Run{}
+		}
+
+		writeJSON(w, http.StatusOK, runs)
+	case http.MethodPost:
+		sub, err := readSubmission(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		run, err := s.Submit(r.Context(), sub.Workflow, sub.Metadata, sub.Params)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, run.snapshot())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// handleRun handles /runs/{id}, /runs/{id}/events and /runs/{id}/cancel
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, runsPath), "/"), "/")
+	if len(parts) > 2 || parts[0] == "" {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	run := s.Find(parts[0])
+	if run == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("run %s not found", parts[0]))
+		return
+	}
+
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, run.details())
+	case action == "" && r.Method == http.MethodDelete,
+		action == "cancel" && r.Method == http.MethodPost:
+		run.cancel()
+		writeJSON(w, http.StatusAccepted, run.snapshot())
+	case action == "events" && r.Method == http.MethodGet:
+		s.streamEvents(w, r, run)
+	case action == "" || action == "cancel" || action == "events":
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// streamEvents sends all events of the run as Server-Sent Events until the run is over
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, run *Run) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	past, subscriber := run.subscribe()
+	defer run.unsubscribe(subscriber)
+
+	for _, line := range past {
+		writeEvent(w, line)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case line, ok := <-subscriber:
+			if !ok {
+				fmt.Fprintf(w, "event: end\ndata: %s\n\n", mustJSON(run.snapshot()))
+				flusher.Flush()
+				return
+			}
+
+			writeEvent(w, line)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// readSubmission reads the workflow, metadata and params of a new run. A
+// JSON body is a submission with the workflow as YAML text or a JSON object.
+// Anything else is the workflow YAML with metadata and params as key=value
+// query parameters
+func readSubmission(r *http.Request) (*submission, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxWorkflowSize))
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &submission{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err = json.Unmarshal(body, sub); err != nil {
+			return nil, err
+		}
+		if len(sub.Workflow) == 0 {
+			return nil, errors.New("no workflow")
+		}
+
+		// a YAML string or a JSON object, which is valid YAML as it is
+		var text string
+		if err = json.Unmarshal(sub.Workflow, &text); err == nil {
+			sub.Workflow = []byte(text)
+		}
+
+		return sub, nil
+	}
+
+	sub.Workflow = body
+	sub.Metadata = make(utils.Metadata)
+	for _, m := range r.URL.Query()["metadata"] {
+		keyValue := strings.SplitN(m, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("invalid metadata %s. It should be key=value", m)
+		}
+
+		sub.Metadata[keyValue[0]] = keyValue[1]
+	}
+
+	sub.Params = make(map[string]interface{})
+	for _, p := range r.URL.Query()["param"] {
+		keyValue := strings.SplitN(p, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("invalid param %s. It should be key=value", p)
+		}
+
+		sub.Params[keyValue[0]] = keyValue[1]
+	}
+
+	return sub, nil
+}
+
+func writeEvent(w http.ResponseWriter, line []byte) {
+	var event struct {
+		Name string `json:"name"`
+	}
+	_ = json.Unmarshal(line, &event)
+
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Name, line)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(mustJSON(value), '\n'))
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func mustJSON(value interface{}) []byte {
+	buff, err := json.Marshal(value)
+	if err != nil {
+		return []byte(fmt.Sprintf(`{"error":%q}`, err.Error()))
+	}
+
+	return buff
+}
@@ -0,0 +1,467 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+func newTestServer(options *Options) *Server {
+	viper.Set("log-type", "discard")
+	viper.Set("log-level", "info")
+	viper.Set("log-format", "text")
+
+	if options.Concurrency == 0 {
+		options.Concurrency = 2
+	}
+	if options.Timeout == 0 {
+		options.Timeout = 5 * time.Second
+	}
+
+	return NewServer(options, logrus.New())
+}
+
+func submit(t *testing.T, server *Server, workflow string) *Run {
+	t.Helper()
+
+	run, err := server.Submit(context.Background(), []byte(workflow), utils.Metadata{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return run
+}
+
+// run with go test -race to check details can be read while the steps run
+func TestRunDetailsWhileRunning(t *testing.T) {
+	server := newTestServer(&Options{})
+	run := submit(t, server, `
+version: 1
+steps:
+  - name: first
+    command: sleep 0.2
+  - name: second
+    command: sleep 0.1
+    depends_on: [first]
+`)
+
+	for {
+		details := run.details()
+		select {
+		case <-run.done:
+			details = run.details()
+			if details.Report.Status != utils.StatusSuccess {
+				t.Fatalf("expected %s, got %s", utils.StatusSuccess, details.Report.Status)
+			}
+			for _, step := range details.Report.Steps {
+				if step.Status != utils.StatusSuccess {
+					t.Errorf("expected step %s to be %s, got %s", step.Name, utils.StatusSuccess, step.Status)
+				}
+			}
+			return
+		default:
+		}
+	}
+}
+
+func TestPruneFinishedRuns(t *testing.T) {
+	server := newTestServer(&Options{KeepRuns: 2})
+
+	var runs []*Run
+	for idx := 0; idx < 4; idx++ {
+		run := submit(t, server, "version: 1\nsteps:\n  - name: quick\n    command: \"true\"\n")
+		<-run.done
+		runs = append(runs, run)
+	}
+	// closeRun prunes after done is closed
+	time.Sleep(50 * time.Millisecond)
+
+	listed := server.Runs()
+	if len(listed) != 2 {
+		t.Fatalf("expected 2 runs, got %d", len(listed))
+	}
+	if listed[0].ID != runs[2].ID || listed[1].ID != runs[3].ID {
+		t.Errorf("expected the newest runs to be kept")
+	}
+	if server.Find(runs[0].ID) != nil {
+		t.Errorf("expected run %s to be forgotten", runs[0].ID)
+	}
+}
+
+func TestPruneOldRuns(t *testing.T) {
+	server := newTestServer(&Options{KeepRunsFor: 10 * time.Millisecond})
+
+	finished := submit(t, server, "version: 1\nsteps:\n  - name: quick\n    command: \"true\"\n")
+	<-finished.done
+	running := submit(t, server, "version: 1\nsteps:\n  - name: slow\n    command: sleep 0.3\n")
+	time.Sleep(50 * time.Millisecond)
+
+	if server.Find(finished.ID) != nil {
+		t.Errorf("expected finished run to be forgotten")
+	}
+	if server.Find(running.ID) == nil {
+		t.Errorf("expected running run to be kept")
+	}
+	<-running.done
+}
+
+func TestPruneRemovesOutputFiles(t *testing.T) {
+	server := newTestServer(&Options{KeepRuns: 1})
+
+	// enough output to spill to a file, which is kept since the step fails
+	failed := submit(t, server, "version: 1\nsteps:\n  - name: noisy\n    command: sh -c 'seq 1000; exit 1'\n")
+	<-failed.done
+	outputFile := failed.details().Report.Steps[0].Runs[0].OutputFile
+	if outputFile == "" {
+		t.Fatal("expected the output of the failed run in a file")
+	}
+
+	next := submit(t, server, "version: 1\nsteps:\n  - name: quick\n    command: \"true\"\n")
+	<-next.done
+	// closeRun prunes after done is closed
+	time.Sleep(50 * time.Millisecond)
+
+	if server.Find(failed.ID) != nil {
+		t.Fatalf("expected run %s to be forgotten", failed.ID)
+	}
+	if _, err := os.Stat(outputFile); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed with its run", outputFile)
+	}
+}
+
+const echoWorkflow = `
+version: 1
+name: echo
+params:
+  - name: environment
+    type: enum
+    values: [staging, production]
+    required: true
+  - name: replicas
+    type: int
+    default: 1
+steps:
+  - name: echo
+    command: "echo {{ .Params.environment }} {{ .Params.replicas }} {{ .MergedMetadataValues.region }}"
+`
+
+// request sends a request to the server and returns the response
+func request(server *Server, method, path, contentType, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+// decode checks the status of the response and reads the run in it
+func decode(t *testing.T, response *httptest.ResponseRecorder, status int) *Run {
+	t.Helper()
+
+	if response.Code != status {
+		t.Fatalf("expected %d, got %d: %s", status, response.Code, response.Body)
+	}
+
+	run := &Run{}
+	if err := json.Unmarshal(response.Body.Bytes(), run); err != nil {
+		t.Fatal(err)
+	}
+
+	return run
+}
+
+// wait waits for the run to finish and returns its details
+func wait(t *testing.T, server *Server, id string) *runDetails {
+	t.Helper()
+
+	run := server.Find(id)
+	if run == nil {
+		t.Fatalf("run %s not found", id)
+	}
+	<-run.done
+
+	return run.details()
+}
+
+func TestSubmit(t *testing.T) {
+	jsonBody := func(workflow interface{}) string {
+		return string(mustJSON(map[string]interface{}{
+			"workflow": workflow,
+			"metadata": map[string]string{"region": "eu"},
+			"params":   map[string]interface{}{"environment": "production", "replicas": 3},
+		}))
+	}
+	object := map[string]interface{}{
+		"version": 1,
+		"name":    "echo",
+		"params":  []map[string]interface{}{{"name": "environment", "type": "string"}, {"name": "replicas", "type": "int"}},
+		"steps":   []map[string]interface{}{{"name": "echo", "command": "echo {{ .Params.environment }} {{ .Params.replicas }} {{ .MergedMetadataValues.region }}"}},
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		body        string
+	}{
+		{
+			name:  "yaml",
+			query: "?metadata=region=eu&param=environment=production&param=replicas=3",
+			body:  echoWorkflow,
+		},
+		{
+			name:        "json with yaml workflow",
+			contentType: "application/json",
+			body:        jsonBody(echoWorkflow),
+		},
+		{
+			name:        "json with json workflow",
+			contentType: "application/json; charset=utf-8",
+			body:        jsonBody(object),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(&Options{})
+			run := decode(t, request(server, http.MethodPost, "/runs"+test.query, test.contentType, test.body, ""), http.StatusCreated)
+			if run.Name != "echo" || run.Metadata.String("region") != "eu" {
+				t.Errorf("unexpected run %+v", run)
+			}
+
+			details := wait(t, server, run.ID)
+			if details.Status != RunSuccess {
+				t.Fatalf("expected %s, got %s: %s", RunSuccess, details.Status, details.Error)
+			}
+			if stdout := details.Report.Steps[0].Runs[0].Stdout; len(stdout) != 1 || stdout[0] != "production 3 eu" {
+				t.Errorf("unexpected output %v", stdout)
+			}
+
+			// listed with the other runs
+			var runs []*Run
+			if err := json.Unmarshal(request(server, http.MethodGet, "/runs", "", "", "").Body.Bytes(), &runs); err != nil {
+				t.Fatal(err)
+			}
+			if len(runs) != 1 || runs[0].ID != run.ID || runs[0].Status != RunSuccess {
+				t.Errorf("unexpected runs %+v", runs)
+			}
+		})
+	}
+}
+
+func TestSubmitErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		body        string
+		expected    string
+	}{
+		{name: "invalid metadata", query: "?metadata=region", body: echoWorkflow, expected: "invalid metadata region"},
+		{name: "invalid param", query: "?param=environment", body: echoWorkflow, expected: "invalid param environment"},
+		{name: "invalid param value", query: "?param=environment=dev", body: echoWorkflow, expected: "dev"},
+		{name: "missing param", body: echoWorkflow, expected: "environment"},
+		{name: "invalid workflow", body: "version: [", expected: "yaml"},
+		{name: "no workflow", contentType: "application/json", body: `{"metadata": {}}`, expected: "no workflow"},
+		{name: "invalid json", contentType: "application/json", body: `{`, expected: "unexpected end"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(&Options{})
+			response := request(server, http.MethodPost, "/runs"+test.query, test.contentType, test.body, "")
+			if response.Code != http.StatusBadRequest || !strings.Contains(response.Body.String(), test.expected) {
+				t.Errorf("expected 400 with %q, got %d: %s", test.expected, response.Code, response.Body)
+			}
+			if len(server.Runs()) != 0 {
+				t.Errorf("expected no runs")
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	server := newTestServer(&Options{Token: "secret"})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		status int
+	}{
+		{name: "no token", method: http.MethodGet, path: "/runs", status: http.StatusUnauthorized},
+		{name: "bad token", method: http.MethodGet, path: "/runs", header: "Bearer wrong", status: http.StatusUnauthorized},
+		{name: "not a bearer", method: http.MethodGet, path: "/runs", header: "Basic secret", status: http.StatusUnauthorized},
+		{name: "submit with bad token", method: http.MethodPost, path: "/runs", header: "Bearer secrets", status: http.StatusUnauthorized},
+		{name: "run with bad token", method: http.MethodGet, path: "/runs/missing", header: "Bearer wrong", status: http.StatusUnauthorized},
+		{name: "good token", method: http.MethodGet, path: "/runs", header: "Bearer secret", status: http.StatusOK},
+		{name: "health check", method: http.MethodGet, path: "/healthz", status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(echoWorkflow))
+			if test.header != "" {
+				req.Header.Set("Authorization", test.header)
+			}
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, req)
+
+			if response.Code != test.status {
+				t.Errorf("expected %d, got %d: %s", test.status, response.Code, response.Body)
+			}
+		})
+	}
+
+	if len(server.Runs()) != 0 {
+		t.Errorf("expected no runs to be submitted")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	server := newTestServer(&Options{MaxRuns: 1, Timeout: 30 * time.Second})
+	slow := "version: 1\nsteps:\n  - name: slow\n    command: sleep 5\n"
+
+	running := decode(t, request(server, http.MethodPost, "/runs", "", slow, ""), http.StatusCreated)
+	for server.Find(running.ID).snapshot().Status != RunRunning {
+		time.Sleep(10 * time.Millisecond)
+	}
+	queued := decode(t, request(server, http.MethodPost, "/runs", "", slow, ""), http.StatusCreated)
+	if status := server.Find(queued.ID).snapshot().Status; status != RunQueued {
+		t.Fatalf("expected the second run to be %s, got %s", RunQueued, status)
+	}
+
+	started := time.Now()
+	decode(t, request(server, http.MethodPost, "/runs/"+queued.ID+"/cancel", "", "", ""), http.StatusAccepted)
+	details := wait(t, server, queued.ID)
+	if details.Status != RunCancelled || details.StartedAt != nil {
+		t.Errorf("expected the queued run to be cancelled before starting, got %s", details.Status)
+	}
+
+	decode(t, request(server, http.MethodDelete, "/runs/"+running.ID, "", "", ""), http.StatusAccepted)
+	details = wait(t, server, running.ID)
+	if details.Status != RunCancelled {
+		t.Errorf("expected the running run to be %s, got %s", RunCancelled, details.Status)
+	}
+	if elapsed := time.Since(started); elapsed > 3*time.Second {
+		t.Errorf("expected the runs to stop, took %s", elapsed)
+	}
+
+	for path, status := range map[string]int{
+		"/runs/missing/cancel":         http.StatusNotFound,
+		"/runs/" + running.ID + "/foo": http.StatusNotFound,
+	} {
+		if response := request(server, http.MethodPost, path, "", "", ""); response.Code != status {
+			t.Errorf("expected %d for %s, got %d", status, path, response.Code)
+		}
+	}
+	if response := request(server, http.MethodGet, "/runs/"+running.ID+"/cancel", "", "", ""); response.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected %d, got %d", http.StatusMethodNotAllowed, response.Code)
+	}
+}
+
+// sseEvents returns the names and data of the Server-Sent Events in body
+func sseEvents(t *testing.T, body string) ([]string, []string) {
+	t.Helper()
+
+	var names, data []string
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		lines := strings.Split(block, "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "event: ") || !strings.HasPrefix(lines[1], "data: ") {
+			t.Fatalf("invalid event %q", block)
+		}
+
+		names = append(names, strings.TrimPrefix(lines[0], "event: "))
+		data = append(data, strings.TrimPrefix(lines[1], "data: "))
+	}
+
+	return names, data
+}
+
+func TestStreamEvents(t *testing.T) {
+	server := newTestServer(&Options{})
+	run := decode(t, request(server, http.MethodPost, "/runs", "", "version: 1\nsteps:\n  - name: quick\n    command: sleep 0.2\n", ""), http.StatusCreated)
+
+	// while the run is going and after it's over
+	for _, when := range []string{"running", "done"} {
+		response := request(server, http.MethodGet, "/runs/"+run.ID+"/events", "", "", "")
+		if contentType := response.Header().Get("Content-Type"); contentType != "text/event-stream" {
+			t.Errorf("%s: unexpected content type %s", when, contentType)
+		}
+
+		names, data := sseEvents(t, response.Body.String())
+		if len(names) < 3 || names[0] != utils.EventWorkflowStarted || names[len(names)-2] != utils.EventWorkflowFinished || names[len(names)-1] != "end" {
+			t.Fatalf("%s: unexpected events %v", when, names)
+		}
+
+		var event struct {
+			Name    string `json:"name"`
+			Payload struct {
+				Workflow string `json:"workflow"`
+			} `json:"payload"`
+		}
+		if err := json.Unmarshal([]byte(data[0]), &event); err != nil || event.Name != utils.EventWorkflowStarted {
+			t.Errorf("%s: unexpected event %s (%v)", when, data[0], err)
+		}
+
+		// the end event has the run
+		end := &Run{}
+		if err := json.Unmarshal([]byte(data[len(data)-1]), end); err != nil {
+			t.Fatal(err)
+		}
+		if end.ID != run.ID || end.Status != RunSuccess {
+			t.Errorf("%s: unexpected run at the end %+v", when, end)
+		}
+	}
+}
+
+func TestMaxRuns(t *testing.T) {
+	server := newTestServer(&Options{MaxRuns: 2})
+	workflow := "version: 1\nsteps:\n  - name: slow\n    command: sleep 0.3\n"
+
+	var ids []string
+	for idx := 0; idx < 3; idx++ {
+		ids = append(ids, decode(t, request(server, http.MethodPost, "/runs", "", workflow, ""), http.StatusCreated).ID)
+	}
+
+	var runs []*runDetails
+	for _, id := range ids {
+		runs = append(runs, wait(t, server, id))
+	}
+
+	for _, run := range runs {
+		if run.Status != RunSuccess {
+			t.Fatalf("expected run %s to be %s, got %s", run.ID, RunSuccess, run.Status)
+		}
+	}
+	// the runs can start in any order but the last one waits for another to finish
+	firstEnd, lastStart := runs[0].EndedAt, runs[0].StartedAt
+	for _, run := range runs[1:] {
+		if run.EndedAt.Before(*firstEnd) {
+			firstEnd = run.EndedAt
+		}
+		if run.StartedAt.After(*lastStart) {
+			lastStart = run.StartedAt
+		}
+	}
+	if lastStart.Before(*firstEnd) {
+		t.Errorf("expected the last run to start after %s, started at %s", firstEnd, lastStart)
+	}
+}
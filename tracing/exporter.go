@@ -0,0 +1,190 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultExportTimeout = 10 * time.Second
+
+// Exporter sends finished spans somewhere
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+// InMemoryExporter keeps all exported spans in memory. It is useful for tests
+type InMemoryExporter struct {
+	spans []*Span
+	lock  sync.Mutex
+}
+
+// NewInMemoryExporter creates a new InMemoryExporter
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+// Export implements Exporter
+func (e *InMemoryExporter) Export(spans []*Span) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// Spans returns all exported spans
+func (e *InMemoryExporter) Spans() []*Span {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return append([]*Span{}, e.spans...)
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP over HTTP (JSON)
+type OTLPExporter struct {
+	url         string
+	headers     map[string]string
+	serviceName string
+	client      *http.Client
+}
+
+// NewOTLPExporter creates a new OTLPExporter. endpoint is the base URL of the
+// collector (like http://localhost:4318). Spans are sent to endpoint/v1/traces
+func NewOTLPExporter(endpoint string, headers map[string]string, serviceName string) *OTLPExporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+
+	return &OTLPExporter{
+		url:         url,
+		headers:     headers,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: defaultExportTimeout},
+	}
+}
+
+// Export implements Exporter
+func (e *OTLPExporter) Export(spans []*Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp endpoint returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func (e *OTLPExporter) request(spans []*Span) map[string]interface{} {
+	var converted []otlpSpan
+	for _, span := range spans {
+		item := otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.SpanID.String(),
+			Name:              span.Name,
+			Kind:              1, // internal
+			StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+			Status:            otlpStatus{Code: span.Status, Message: span.StatusMessage},
+		}
+		if span.ParentSpanID.IsValid() {
+			item.ParentSpanID = span.ParentSpanID.String()
+		}
+
+		converted = append(converted, item)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]interface{}{"service.name": e.serviceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "trackman"},
+						"spans": converted,
+					},
+				},
+			},
+		},
+	}
+}
+
+func otlpAttributes(attributes map[string]interface{}) []otlpAttribute {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var result []otlpAttribute
+	for _, key := range keys {
+		var value map[string]interface{}
+		switch typed := attributes[key].(type) {
+		case bool:
+			value = map[string]interface{}{"boolValue": typed}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(typed)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(typed, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": typed}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprintf("%v", typed)}
+		}
+
+		result = append(result, otlpAttribute{Key: key, Value: value})
+	}
+
+	return result
+}
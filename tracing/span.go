@@ -0,0 +1,115 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// StatusUnset is the status of a span that hasn't reported success or failure
+	StatusUnset = 0
+	// StatusOK is the status of a successful span
+	StatusOK = 1
+	// StatusError is the status of a failed span
+	StatusError = 2
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// String returns the hex representation of the trace ID
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid returns false for an all zero trace ID
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// String returns the hex representation of the span ID
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid returns false for an all zero span ID
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// Span is a single timed operation within a trace
+type Span struct {
+	TraceID       TraceID
+	SpanID        SpanID
+	ParentSpanID  SpanID
+	Name          string
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    map[string]interface{}
+	Status        int
+	StatusMessage string
+}
+
+func newSpan(traceID TraceID, parentSpanID SpanID, name string, start time.Time) *Span {
+	return &Span{
+		TraceID:      traceID,
+		SpanID:       newSpanID(),
+		ParentSpanID: parentSpanID,
+		Name:         name,
+		StartTime:    start,
+		Attributes:   make(map[string]interface{}),
+	}
+}
+
+// end finishes the span. A non empty errorMessage marks the span as failed
+func (s *Span) end(at time.Time, errorMessage string) {
+	s.EndTime = at
+	if errorMessage != "" {
+		s.Status = StatusError
+		s.StatusMessage = errorMessage
+	} else {
+		s.Status = StatusOK
+	}
+}
+
+// TraceParent returns the W3C traceparent header value for the span
+func (s *Span) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-01", s.TraceID, s.SpanID)
+}
+
+// ParseTraceParent parses a W3C traceparent value into its trace and span IDs
+func ParseTraceParent(value string) (TraceID, SpanID, error) {
+	var traceID TraceID
+	var spanID SpanID
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return traceID, spanID, fmt.Errorf("invalid traceparent %s", value)
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, spanID, err
+	}
+	if _, err := hex.Decode(spanID[:], []byte(parts[2])); err != nil {
+		return traceID, spanID, err
+	}
+
+	return traceID, spanID, nil
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	_, _ = rand.Read(id[:])
+	return id
+}
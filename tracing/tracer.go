@@ -0,0 +1,216 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// TraceParentEnv is the environment variable used to pass the trace context to
+// step commands, and to Trackman itself
+const TraceParentEnv = "TRACEPARENT"
+
+// Tracer turns workflow events into a trace: the workflow is the root span,
+// preflight processes and steps are its children and the run and probe
+// processes of a step are children of the step span. It can be added to the
+// notifiers of a workflow
+type Tracer struct {
+	exporter     Exporter
+	traceID      TraceID
+	parentSpanID SpanID
+
+	root     *Span
+	steps    map[string]*Span
+	spinners map[string]*Span
+	finished []*Span
+	lock     sync.Mutex
+}
+
+// NewTracer creates a new Tracer that sends the spans to exporter once the
+// workflow finishes. If traceParent is a valid W3C traceparent, the workflow
+// joins that trace
+func NewTracer(exporter Exporter, traceParent string) *Tracer {
+	tracer := &Tracer{
+		exporter: exporter,
+		traceID:  newTraceID(),
+		steps:    make(map[string]*Span),
+		spinners: make(map[string]*Span),
+	}
+
+	if traceParent != "" {
+		if traceID, spanID, err := ParseTraceParent(traceParent); err == nil && traceID.IsValid() {
+			tracer.traceID = traceID
+			tracer.parentSpanID = spanID
+		}
+	}
+
+	return tracer
+}
+
+// Notify implements notifiers.Notifier
+func (t *Tracer) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	payload := event.Payload
+
+	switch event.Name {
+	case utils.EventWorkflowStarted:
+		t.workflowSpan(payload)
+	case utils.EventWorkflowFinished, utils.EventWorkflowFailed:
+		root := t.workflowSpan(payload)
+		root.end(payload.Timestamp, payload.Error)
+
+		// close anything left open
+		for _, span := range t.steps {
+			if span.EndTime.IsZero() {
+				span.end(payload.Timestamp, "")
+			}
+		}
+
+		return t.flush()
+	case utils.EventStepStarted:
+		t.stepSpan(payload)
+	case utils.EventStepFinished:
+		span := t.stepSpan(payload)
+		// the name can be a template which is only parsed once the step starts
+		span.Name = payload.StepName
+		span.Attributes["trackman.step"] = payload.StepName
+		span.end(payload.Timestamp, payload.Error)
+	case utils.EventPreflightFailed:
+		span := t.stepSpan(payload)
+		span.Attributes["trackman.step.status"] = event.Name
+		message := payload.Error
+		if message == "" {
+			message = "preflight check failed"
+		}
+		span.end(payload.Timestamp, message)
+	case utils.EventStepDisabled, utils.EventStepSkipped, utils.EventStepCancelled:
+		span := t.stepSpan(payload)
+		span.Attributes["trackman.step.status"] = event.Name
+		span.end(payload.Timestamp, "")
+	case utils.EventRunRequested:
+		// preflights run before their step starts, so the span of a step
+		// with preflights starts with its first preflight
+		parent := t.stepSpan(payload)
+
+		span := newSpan(t.traceID, parent.SpanID, payload.Spinner.Name, payload.Timestamp)
+		span.Attributes["trackman.step"] = payload.StepName
+		span.Attributes["trackman.spinner.kind"] = payload.Spinner.Kind
+		span.Attributes["trackman.spinner.attempt"] = payload.Attempt
+		for key, value := range payload.Workflow.Masker().MaskMap(payload.Step.MergedMetadata()) {
+			span.Attributes["trackman.metadata."+key] = value
+		}
+		t.spinners[payload.Spinner.UUID] = span
+	case utils.EventRunSuccess, utils.EventRunFail, utils.EventRunTimeout, utils.EventRunError, utils.EventRunWaitError:
+		span, ok := t.spinners[payload.Spinner.UUID]
+		if !ok {
+			return nil
+		}
+
+		if payload.ExitCode != nil {
+			span.Attributes["trackman.exit_code"] = *payload.ExitCode
+		}
+		if payload.Signal != "" {
+			span.Attributes["trackman.signal"] = payload.Signal
+		}
+		span.Attributes["trackman.timed_out"] = payload.TimedOut
+		span.end(payload.Timestamp, payload.Error)
+
+		delete(t.spinners, payload.Spinner.UUID)
+		t.finished = append(t.finished, span)
+	}
+
+	return nil
+}
+
+// Env returns the TRACEPARENT environment variable for a spinner so the
+// command it runs can join the trace. It is used as WorkflowOptions.SpinnerEnv
+func (t *Tracer) Env(ctx context.Context, spinner *utils.Spinner) []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	span, ok := t.spinners[spinner.UUID]
+	if !ok {
+		return nil
+	}
+
+	return []string{fmt.Sprintf("%s=%s", TraceParentEnv, span.TraceParent())}
+}
+
+// Close implements notifiers.Notifier
+func (t *Tracer) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.flush()
+}
+
+// flush sends the finished spans to the exporter
+func (t *Tracer) flush() error {
+	if len(t.finished) == 0 {
+		return nil
+	}
+
+	spans := t.finished
+	t.finished = nil
+
+	for _, span := range spans {
+		if span.EndTime.IsZero() {
+			span.end(time.Now(), "")
+		}
+	}
+
+	return t.exporter.Export(spans)
+}
+
+func (t *Tracer) workflowSpan(payload utils.Payload) *Span {
+	if t.root != nil {
+		return t.root
+	}
+
+	start := payload.Timestamp
+	if payload.StartedAt != nil {
+		start = *payload.StartedAt
+	}
+
+	workflow := payload.Workflow
+	t.root = newSpan(t.traceID, t.parentSpanID, fmt.Sprintf("workflow %s", workflow.Name), start)
+	t.root.Attributes["trackman.workflow"] = workflow.Name
+	t.root.Attributes["trackman.session_id"] = workflow.SessionID()
+	for key, value := range workflow.Masker().MaskMap(workflow.Metadata.Flatten()) {
+		t.root.Attributes["trackman.metadata."+key] = value
+	}
+	t.finished = append(t.finished, t.root)
+
+	return t.root
+}
+
+// stepSpan returns the span of the step, creating it if needed. Spans are
+// keyed by the step ID since the name of a step can change when it's parsed
+func (t *Tracer) stepSpan(payload utils.Payload) *Span {
+	step := payload.Step
+	if span, ok := t.steps[step.ID()]; ok {
+		return span
+	}
+
+	start := payload.Timestamp
+	if payload.StartedAt != nil && payload.Spinner == nil {
+		start = *payload.StartedAt
+	}
+
+	span := newSpan(t.traceID, t.workflowSpan(payload).SpanID, payload.StepName, start)
+	span.Attributes["trackman.step"] = payload.StepName
+	for key, value := range payload.Workflow.Masker().MaskMap(step.MergedMetadata()) {
+		span.Attributes["trackman.metadata."+key] = value
+	}
+
+	t.steps[step.ID()] = span
+	t.finished = append(t.finished, span)
+
+	return span
+}
@@ -0,0 +1,154 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/spf13/viper"
+)
+
+// runTraced runs a workflow with a tracer and returns the exported spans by
+// name. Process spans are named after their kind too, like build (step)
+func runTraced(t *testing.T, workflow string) map[string]*Span {
+	t.Helper()
+
+	viper.Set("log-type", "discard")
+	viper.Set("log-level", "info")
+	viper.Set("log-format", "text")
+
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter, "")
+
+	ctx := context.Background()
+	options := &utils.WorkflowOptions{
+		Notifier:    tracer.Notify,
+		SpinnerEnv:  tracer.Env,
+		Concurrency: 2,
+		Timeout:     10 * time.Second,
+	}
+	loaded, err := utils.LoadWorkflowFromBytes(ctx, options, []byte(workflow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.Run(ctx)
+
+	spans := make(map[string]*Span)
+	for _, span := range exporter.Spans() {
+		name := span.Name
+		if kind, ok := span.Attributes["trackman.spinner.kind"]; ok {
+			name = fmt.Sprintf("%s (%s)", name, kind)
+		}
+		if _, ok := spans[name]; ok {
+			t.Errorf("more than one span named %s", name)
+		}
+		spans[name] = span
+	}
+
+	return spans
+}
+
+func TestTracer(t *testing.T) {
+	spans := runTraced(t, `
+version: 1
+name: build
+steps:
+  - name: "compile {{ .Metadata.region }}"
+    metadata:
+      region: eu
+    command: "true"
+    preflights:
+      - command: "true"
+  - name: package
+    command: sleep 0.1
+    depends_on: ["compile {{ .Metadata.region }}"]
+`)
+
+	root, ok := spans["workflow build"]
+	if !ok {
+		t.Fatalf("no workflow span in %v", spans)
+	}
+
+	// the span has the parsed name of the step
+	compile, ok := spans["compile eu"]
+	if !ok {
+		t.Fatalf("no span for the templated step in %v", spans)
+	}
+	pkg := spans["package"]
+	preflight := spans["compile {{ .Metadata.region }}.preflight (preflight)"]
+	if pkg == nil || preflight == nil {
+		t.Fatalf("missing spans in %v", spans)
+	}
+
+	if compile.ParentSpanID != root.SpanID || pkg.ParentSpanID != root.SpanID {
+		t.Errorf("steps should be children of the workflow")
+	}
+	if preflight.ParentSpanID != compile.SpanID {
+		t.Errorf("preflights should be children of their step")
+	}
+	for _, span := range spans {
+		if span.Status != StatusOK {
+			t.Errorf("expected span %s to be OK, got %d (%s)", span.Name, span.Status, span.StatusMessage)
+		}
+		if span.TraceID != root.TraceID {
+			t.Errorf("span %s is in another trace", span.Name)
+		}
+	}
+
+	// steps without preflights start when they run, not when the workflow starts
+	if !pkg.StartTime.After(compile.EndTime) && !pkg.StartTime.Equal(compile.EndTime) {
+		t.Errorf("package started at %s before compile ended at %s", pkg.StartTime, compile.EndTime)
+	}
+	if preflight.StartTime.Before(compile.StartTime) || preflight.EndTime.After(compile.EndTime) {
+		t.Errorf("preflight span is outside of its step")
+	}
+
+	var runs int
+	for _, span := range spans {
+		if span.ParentSpanID == pkg.SpanID {
+			runs++
+			if span.StartTime.Before(pkg.StartTime) || span.EndTime.After(pkg.EndTime) {
+				t.Errorf("run span %s is outside of its step", span.Name)
+			}
+		}
+	}
+	if runs != 1 {
+		t.Errorf("expected 1 run span for package, got %d", runs)
+	}
+}
+
+func TestTracerPreflightFailed(t *testing.T) {
+	spans := runTraced(t, `
+version: 1
+name: deploy
+steps:
+  - name: deploy
+    command: "true"
+    preflights:
+      - command: "false"
+        message: not ready
+`)
+
+	step, ok := spans["deploy"]
+	if !ok {
+		t.Fatalf("no span for the step in %v", spans)
+	}
+	if step.Status != StatusError {
+		t.Errorf("expected the step span to be an error, got %d", step.Status)
+	}
+	preflight, ok := spans["deploy.preflight (preflight)"]
+	if !ok {
+		t.Fatalf("no span for the preflight in %v", spans)
+	}
+	if preflight.ParentSpanID != step.SpanID {
+		t.Errorf("expected the preflight to be a child of its step")
+	}
+	if preflight.Status != StatusError {
+		t.Errorf("expected the preflight span to be an error, got %d", preflight.Status)
+	}
+	if root := spans["workflow deploy"]; root == nil || root.Status != StatusError {
+		t.Errorf("expected the workflow span to be an error")
+	}
+}
@@ -0,0 +1,351 @@
+package tui
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultWidth  = 80
+	defaultHeight = 24
+
+	// refreshInterval is how often the dashboard is drawn
+	refreshInterval = 100 * time.Millisecond
+	// tailLines is the number of output lines shown under each running step
+	tailLines = 3
+
+	enterAltScreen = "\x1b[?1049h\x1b[?25l"
+	exitAltScreen  = "\x1b[?25h\x1b[?1049l"
+)
+
+// stepState is what the dashboard knows about a step
+type stepState struct {
+	id        string
+	name      string
+	dependsOn []string
+	status    string
+	startedAt time.Time
+	endedAt   time.Time
+	spinner   *utils.Spinner
+	attempt   int
+	err       string
+}
+
+// confirmation is a question waiting for an answer from the keyboard
+type confirmation struct {
+	step   string
+	answer chan bool
+}
+
+// Dashboard is a notifier that shows a live view of a workflow run in the terminal
+type Dashboard struct {
+	out       *os.File
+	in        *os.File
+	interrupt func()
+
+	workflow  string
+	sessionID string
+	startedAt time.Time
+	steps     []*stepState
+	byID      map[string]*stepState
+	byName    map[string]*stepState
+	selected  int
+	expanded  bool
+	scroll    int
+	log       *outputLog
+	question  *confirmation
+	stopping  bool
+
+	lock      sync.Mutex
+	restore   func()
+	startOnce sync.Once
+	stopOnce  sync.Once
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// NewDashboard creates a new Dashboard drawing to out and reading keys from in.
+// interrupt is called when ctrl+c is pressed
+func NewDashboard(out *os.File, in *os.File, interrupt func()) *Dashboard {
+	return &Dashboard{
+		out:       out,
+		in:        in,
+		interrupt: interrupt,
+		byID:      make(map[string]*stepState),
+		byName:    make(map[string]*stepState),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// Notify implements notifiers.Notifier
+func (d *Dashboard) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	if event.Name == utils.EventWorkflowStarted {
+		d.load(event.Payload.Workflow)
+		d.start()
+
+		return nil
+	}
+
+	d.lock.Lock()
+	d.apply(event)
+	d.lock.Unlock()
+
+	if event.Name == utils.EventWorkflowFinished || event.Name == utils.EventWorkflowFailed {
+		d.stop()
+	}
+
+	return nil
+}
+
+// Close implements notifiers.Notifier
+func (d *Dashboard) Close() error {
+	d.stop()
+
+	return nil
+}
+
+// Confirm asks if a step should run and waits for y or n to be pressed.
+// It can be used as WorkflowOptions.Confirm
+func (d *Dashboard) Confirm(ctx context.Context, step *utils.Step) bool {
+	question := &confirmation{
+		step:   step.Name,
+		answer: make(chan bool, 1),
+	}
+
+	d.lock.Lock()
+	d.question = question
+	d.lock.Unlock()
+
+	defer func() {
+		d.lock.Lock()
+		if d.question == question {
+			d.question = nil
+		}
+		d.lock.Unlock()
+	}()
+
+	select {
+	case answer := <-question.answer:
+		return answer
+	case <-ctx.Done():
+		return false
+	case <-d.done:
+		return false
+	}
+}
+
+func (d *Dashboard) load(workflow *utils.Workflow) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.workflow = workflow.Name
+	d.sessionID = workflow.SessionID()
+	d.startedAt = time.Now()
+	for _, step := range workflow.Steps {
+		state := &stepState{
+			id:        step.ID(),
+			name:      step.Name,
+			dependsOn: step.DependsOn,
+			status:    utils.StatusPending,
+		}
+
+		d.steps = append(d.steps, state)
+		d.byID[step.ID()] = state
+		// depends_on uses the names in the workflow file
+		d.byName[step.Name] = state
+	}
+}
+
+// apply updates the state of the steps with the event. It should be called with the lock held
+func (d *Dashboard) apply(event *utils.Event) {
+	if event.Payload.Step == nil {
+		return
+	}
+
+	// steps are renamed once their name is parsed, so they are found by ID
+	state, ok := d.byID[event.Payload.Step.ID()]
+	if !ok {
+		return
+	}
+	if event.Payload.StepName != "" {
+		state.name = event.Payload.StepName
+	}
+
+	switch event.Name {
+	case utils.EventStepStarted:
+		state.status = utils.StatusRunning
+		state.startedAt = event.Payload.Timestamp
+	case utils.EventRunRequested:
+		state.spinner = event.Payload.Spinner
+		state.attempt = event.Payload.Attempt
+	case utils.EventStepFinished:
+		state.endedAt = event.Payload.Timestamp
+		state.err = event.Payload.Error
+		if state.err != "" {
+			state.status = utils.StatusFailed
+		} else {
+			state.status = utils.StatusSuccess
+		}
+	case utils.EventPreflightFailed:
+		state.status = utils.StatusFailed
+		state.err = event.Payload.Error
+	case utils.EventStepDisabled:
+		state.status = utils.StatusDisabled
+	case utils.EventStepSkipped:
+		state.status = utils.StatusSkipped
+	case utils.EventStepCancelled:
+		state.status = utils.StatusCancelled
+	}
+}
+
+func (d *Dashboard) start() {
+	d.startOnce.Do(func() {
+		restore, err := makeRaw(int(d.in.Fd()))
+		if err == nil {
+			d.restore = restore
+		}
+
+		_, _ = io.WriteString(d.out, enterAltScreen)
+
+		go d.readKeys()
+		go d.refresh()
+	})
+}
+
+func (d *Dashboard) stop() {
+	d.startOnce.Do(func() {
+		// never started so there is nothing to clean up
+		close(d.stopped)
+	})
+
+	d.stopOnce.Do(func() {
+		close(d.done)
+		<-d.stopped
+
+		_, _ = io.WriteString(d.out, exitAltScreen)
+		if d.restore != nil {
+			d.restore()
+		}
+	})
+}
+
+func (d *Dashboard) refresh() {
+	defer close(d.stopped)
+
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		d.draw()
+
+		select {
+		case <-ticker.C:
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *Dashboard) draw() {
+	width, height := terminalSize(int(d.out.Fd()))
+
+	d.lock.Lock()
+	lines := d.render(width, height)
+	d.lock.Unlock()
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("\x1b[H")
+	for idx, line := range lines {
+		buf.WriteString(line)
+		buf.WriteString("\x1b[K")
+		if idx < len(lines)-1 {
+			buf.WriteString("\r\n")
+		}
+	}
+	buf.WriteString("\x1b[J")
+
+	_, _ = d.out.Write(buf.Bytes())
+}
+
+func (d *Dashboard) readKeys() {
+	buf := make([]byte, 64)
+	for {
+		n, err := d.in.Read(buf)
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-d.done:
+			return
+		default:
+		}
+
+		for _, key := range parseKeys(buf[:n]) {
+			d.handleKey(key)
+		}
+	}
+}
+
+func (d *Dashboard) handleKey(key string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if key == keyInterrupt {
+		if !d.stopping && d.interrupt != nil {
+			d.stopping = true
+			go d.interrupt()
+		}
+		return
+	}
+
+	if d.question != nil {
+		switch key {
+		case "y", "Y":
+			d.question.answer <- true
+			d.question = nil
+		case "n", "N", keyEnter, keyEscape:
+			d.question.answer <- false
+			d.question = nil
+		}
+		return
+	}
+
+	if d.expanded {
+		switch key {
+		case keyUp, "k":
+			d.scroll++
+		case keyDown, "j":
+			if d.scroll > 0 {
+				d.scroll--
+			}
+		case keyEscape, keyEnter, "q", "h":
+			d.expanded = false
+		}
+		return
+	}
+
+	switch key {
+	case keyUp, "k":
+		if d.selected > 0 {
+			d.selected--
+		}
+	case keyDown, "j":
+		if d.selected < len(d.steps)-1 {
+			d.selected++
+		}
+	case keyEnter, "l":
+		if len(d.steps) > 0 {
+			d.expanded = true
+			d.scroll = 0
+		}
+	}
+}
@@ -0,0 +1,433 @@
+package tui
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/fatih/color"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func loadWorkflow(t *testing.T, notifier func(context.Context, *logrus.Logger, *utils.Event) error, workflow string) *utils.Workflow {
+	t.Helper()
+
+	viper.Set("log-type", "discard")
+	viper.Set("log-level", "info")
+	viper.Set("log-format", "text")
+
+	if notifier == nil {
+		notifier = func(context.Context, *logrus.Logger, *utils.Event) error { return nil }
+	}
+	options := &utils.WorkflowOptions{
+		Notifier:    notifier,
+		Concurrency: 2,
+		Timeout:     10 * time.Second,
+	}
+	loaded, err := utils.LoadWorkflowFromBytes(context.Background(), options, []byte(workflow))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return loaded
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"j", []string{"j"}},
+		{"\x1b[A\x1b[B", []string{keyUp, keyDown}},
+		{"\r", []string{keyEnter}},
+		{"\n", []string{keyEnter}},
+		{"\x1b", []string{keyEscape}},
+		{"\x03", []string{keyInterrupt}},
+		{"y\x1b[Ak", []string{"y", keyUp, "k"}},
+		// other escape sequences are ignored
+		{"\x1b[C", nil},
+	}
+
+	for _, test := range tests {
+		if keys := parseKeys([]byte(test.input)); !reflect.DeepEqual(keys, test.expected) {
+			t.Errorf("expected %q to be %v, got %v", test.input, test.expected, keys)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	workflow := loadWorkflow(t, nil, `
+version: 1
+name: deploy
+steps:
+  - name: "build {{ .Metadata.region }}"
+    metadata:
+      region: eu
+    command: "true"
+  - name: test
+    command: "true"
+`)
+	build, test := workflow.Steps[0], workflow.Steps[1]
+
+	event := func(step *utils.Step, name string, stepName string, err string) *utils.Event {
+		return &utils.Event{
+			Name: name,
+			Payload: utils.Payload{
+				Workflow: workflow,
+				Step:     step,
+				StepName: stepName,
+				Error:    err,
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		events   []*utils.Event
+		expected stepState
+	}{
+		{
+			name:     "pending",
+			events:   nil,
+			expected: stepState{name: build.Name, status: utils.StatusPending},
+		},
+		{
+			name:     "running",
+			events:   []*utils.Event{event(build, utils.EventStepStarted, build.Name, "")},
+			expected: stepState{name: build.Name, status: utils.StatusRunning},
+		},
+		{
+			// the step is renamed once its name is parsed
+			name: "renamed",
+			events: []*utils.Event{
+				event(build, utils.EventStepStarted, build.Name, ""),
+				event(build, utils.EventStepFinished, "build eu", ""),
+			},
+			expected: stepState{name: "build eu", status: utils.StatusSuccess},
+		},
+		{
+			name: "failed",
+			events: []*utils.Event{
+				event(build, utils.EventStepStarted, build.Name, ""),
+				event(build, utils.EventStepFinished, "build eu", "exit status 1"),
+			},
+			expected: stepState{name: "build eu", status: utils.StatusFailed, err: "exit status 1"},
+		},
+		{
+			name:     "preflight failed",
+			events:   []*utils.Event{event(build, utils.EventPreflightFailed, build.Name, "not ready")},
+			expected: stepState{name: build.Name, status: utils.StatusFailed, err: "not ready"},
+		},
+		{
+			name:     "disabled",
+			events:   []*utils.Event{event(build, utils.EventStepDisabled, build.Name, "")},
+			expected: stepState{name: build.Name, status: utils.StatusDisabled},
+		},
+		{
+			name:     "skipped",
+			events:   []*utils.Event{event(build, utils.EventStepSkipped, build.Name, "")},
+			expected: stepState{name: build.Name, status: utils.StatusSkipped},
+		},
+		{
+			name:     "cancelled",
+			events:   []*utils.Event{event(build, utils.EventStepCancelled, "build eu", "")},
+			expected: stepState{name: "build eu", status: utils.StatusCancelled},
+		},
+		{
+			name:     "other step",
+			events:   []*utils.Event{event(test, utils.EventStepStarted, test.Name, "")},
+			expected: stepState{name: build.Name, status: utils.StatusPending},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dashboard := NewDashboard(nil, nil, nil)
+			dashboard.load(workflow)
+			for _, event := range test.events {
+				dashboard.apply(event)
+			}
+
+			state := dashboard.steps[0]
+			if state.name != test.expected.name || state.status != test.expected.status || state.err != test.expected.err {
+				t.Errorf("expected %s (%s, %q), got %s (%s, %q)", test.expected.name, test.expected.status, test.expected.err, state.name, state.status, state.err)
+			}
+		})
+	}
+}
+
+// a step with a templated name is renamed while it runs. Its events should
+// still find it
+func TestApplyTemplatedName(t *testing.T) {
+	dashboard := NewDashboard(nil, nil, nil)
+	workflow := loadWorkflow(t, func(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+		dashboard.lock.Lock()
+		defer dashboard.lock.Unlock()
+
+		dashboard.apply(event)
+		return nil
+	}, `
+version: 1
+name: deploy
+steps:
+  - name: "build {{ .Metadata.region }}"
+    metadata:
+      region: eu
+    command: "true"
+  - name: test
+    command: "false"
+    depends_on: ["build {{ .Metadata.region }}"]
+`)
+	dashboard.load(workflow)
+	workflow.Run(context.Background())
+
+	expected := []stepState{
+		{name: "build eu", status: utils.StatusSuccess, attempt: 1},
+		{name: "test", status: utils.StatusFailed, attempt: 1},
+	}
+	for idx, state := range dashboard.steps {
+		if state.name != expected[idx].name || state.status != expected[idx].status || state.attempt != expected[idx].attempt {
+			t.Errorf("expected %s to be %s (attempt %d), got %s (attempt %d)", expected[idx].name, expected[idx].status, expected[idx].attempt, state.status, state.attempt)
+		}
+	}
+}
+
+// view is the part of the dashboard changed by keys
+type view struct {
+	selected int
+	expanded bool
+	scroll   int
+	stopping bool
+}
+
+func TestHandleKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   view
+		keys     []string
+		expected view
+	}{
+		{
+			name:     "select down",
+			keys:     []string{keyDown, "j"},
+			expected: view{selected: 2},
+		},
+		{
+			name:     "stop at the last step",
+			keys:     []string{keyDown, keyDown, keyDown, keyDown},
+			expected: view{selected: 2},
+		},
+		{
+			name:     "select up",
+			before:   view{selected: 2},
+			keys:     []string{keyUp, "k", keyUp},
+			expected: view{selected: 0},
+		},
+		{
+			name:     "open log",
+			before:   view{selected: 1, scroll: 4},
+			keys:     []string{keyEnter},
+			expected: view{selected: 1, expanded: true},
+		},
+		{
+			name:     "scroll log",
+			before:   view{expanded: true},
+			keys:     []string{keyUp, "k", keyUp, keyDown},
+			expected: view{expanded: true, scroll: 2},
+		},
+		{
+			name:     "scroll stops at the end of the log",
+			before:   view{expanded: true, scroll: 1},
+			keys:     []string{"j", "j"},
+			expected: view{expanded: true},
+		},
+		{
+			name:     "close log",
+			before:   view{selected: 1, expanded: true, scroll: 3},
+			keys:     []string{keyEscape},
+			expected: view{selected: 1, scroll: 3},
+		},
+		{
+			name:     "stop",
+			keys:     []string{keyInterrupt, "j"},
+			expected: view{selected: 1, stopping: true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dashboard := NewDashboard(nil, nil, func() {})
+			dashboard.steps = []*stepState{{name: "a"}, {name: "b"}, {name: "c"}}
+			dashboard.selected, dashboard.expanded, dashboard.scroll = test.before.selected, test.before.expanded, test.before.scroll
+			for _, key := range test.keys {
+				dashboard.handleKey(key)
+			}
+
+			actual := view{
+				selected: dashboard.selected,
+				expanded: dashboard.expanded,
+				scroll:   dashboard.scroll,
+				stopping: dashboard.stopping,
+			}
+			if actual != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestHandleKeyConfirm(t *testing.T) {
+	tests := []struct {
+		key      string
+		expected bool
+	}{
+		{"y", true},
+		{"Y", true},
+		{"n", false},
+		{keyEnter, false},
+		{keyEscape, false},
+	}
+
+	for _, test := range tests {
+		question := &confirmation{step: "deploy", answer: make(chan bool, 1)}
+		dashboard := NewDashboard(nil, nil, nil)
+		dashboard.question = question
+		dashboard.steps = []*stepState{{name: "deploy"}, {name: "notify"}}
+		// keys that don't answer the question are ignored
+		dashboard.handleKey(keyDown)
+		dashboard.handleKey(test.key)
+
+		if dashboard.question != nil {
+			t.Fatalf("expected %s to answer the question", test.key)
+		}
+		if answer := <-question.answer; answer != test.expected {
+			t.Errorf("expected %s to answer %t, got %t", test.key, test.expected, answer)
+		}
+		if dashboard.selected != 0 {
+			t.Errorf("expected the selection not to change while asking")
+		}
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	interrupted := make(chan struct{}, 2)
+	dashboard := NewDashboard(nil, nil, func() { interrupted <- struct{}{} })
+	dashboard.handleKey(keyInterrupt)
+	dashboard.handleKey(keyInterrupt)
+
+	<-interrupted
+	select {
+	case <-interrupted:
+		t.Error("expected interrupt to be called once")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRender(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	startedAt := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	states := []*stepState{
+		{name: "build", status: utils.StatusSuccess, startedAt: startedAt, endedAt: startedAt.Add(1500 * time.Millisecond)},
+		{name: "test", status: utils.StatusFailed, startedAt: startedAt, endedAt: startedAt.Add(3 * time.Second), err: "exit status 1\nmore details"},
+		{name: "lint", status: utils.StatusDisabled},
+		{name: "package", status: utils.StatusCancelled},
+		{name: "publish", dependsOn: []string{"test"}, status: utils.StatusPending},
+		{name: "notify", dependsOn: []string{"build", "docs"}, status: utils.StatusPending},
+		{name: "docs", status: utils.StatusPending},
+		{name: "cleanup", status: utils.StatusSkipped},
+	}
+
+	dashboard := NewDashboard(nil, nil, nil)
+	dashboard.workflow = "deploy"
+	dashboard.sessionID = "session"
+	dashboard.selected = 1
+	dashboard.steps = states
+	for _, state := range states {
+		dashboard.byName[state.name] = state
+	}
+
+	screens := []string{strings.Join(dashboard.render(60, 20), "\n")}
+
+	// the selected step stays on a short screen
+	dashboard.selected = 7
+	screens = append(screens, strings.Join(dashboard.render(60, 6), "\n"))
+
+	dashboard.question = &confirmation{step: "docs"}
+	screens = append(screens, strings.Join(dashboard.render(60, 20), "\n"))
+
+	checkGolden(t, "render.golden", strings.Join(screens, "\n----\n")+"\n")
+}
+
+// the log of a step includes the output that didn't fit in memory
+func TestRenderLogFromFile(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	dashboard := NewDashboard(nil, nil, nil)
+	workflow := loadWorkflow(t, func(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+		dashboard.lock.Lock()
+		defer dashboard.lock.Unlock()
+
+		dashboard.apply(event)
+		return nil
+	}, `
+version: 1
+name: deploy
+steps:
+  - name: noisy
+    command: sh -c 'seq 1000; exit 1'
+`)
+	dashboard.load(workflow)
+	workflow.Run(context.Background())
+	defer utils.RemoveOutputFile(dashboard.steps[0].spinner.OutputFile())
+
+	if dashboard.steps[0].spinner.OutputFile() == "" {
+		t.Fatal("expected the output to spill to a file")
+	}
+
+	dashboard.expanded = true
+	// scroll to the top of the log
+	dashboard.scroll = 2000
+	lines := dashboard.render(40, 5)
+	if len(lines) != 5 || lines[2] != "1" || lines[3] != "2" {
+		t.Errorf("expected the first lines of the output, got %q", lines)
+	}
+
+	dashboard.scroll = 0
+	lines = dashboard.render(40, 5)
+	if lines[2] != "999" || lines[3] != "1000" {
+		t.Errorf("expected the last lines of the output, got %q", lines)
+	}
+}
+
+func checkGolden(t *testing.T, name string, actual string) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, []byte(actual), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(expected) != actual {
+		t.Errorf("%s doesn't match. Run go test -update if the change is expected\nexpected:\n%s\ngot:\n%s", path, expected, actual)
+	}
+}
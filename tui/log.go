@@ -0,0 +1,72 @@
+package tui
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+// outputLog reads the output file of a spinner as it grows, so the log view
+// shows all of the output and not only the lines kept in memory
+type outputLog struct {
+	spinner *utils.Spinner
+	file    string
+	offset  int64
+	partial string
+	lines   []string
+}
+
+func newOutputLog(spinner *utils.Spinner) *outputLog {
+	return &outputLog{spinner: spinner}
+}
+
+// Lines returns all of the output of the spinner. It returns the lines kept
+// in memory if the output fits in memory or its file has been removed
+func (l *outputLog) Lines() []string {
+	if l.spinner == nil {
+		return nil
+	}
+
+	_ = l.spinner.FlushOutput()
+	file := l.spinner.OutputFile()
+	if file == "" {
+		return l.spinner.Output()
+	}
+
+	if file != l.file {
+		l.file, l.offset, l.partial, l.lines = file, 0, "", nil
+	}
+	if err := l.read(); err != nil {
+		return l.spinner.Output()
+	}
+
+	return l.lines
+}
+
+// read adds the lines written to the file since the last read
+func (l *outputLog) read() error {
+	file, err := os.Open(l.file)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err = file.Seek(l.offset, io.SeekStart); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	l.offset += int64(len(data))
+
+	parts := strings.Split(l.partial+string(data), "\n")
+	// the last part is empty or a line that is still being written
+	l.partial = parts[len(parts)-1]
+	l.lines = append(l.lines, parts[:len(parts)-1]...)
+
+	return nil
+}
@@ -0,0 +1,332 @@
+package tui
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/fatih/color"
+)
+
+const (
+	keyUp        = "up"
+	keyDown      = "down"
+	keyEnter     = "enter"
+	keyEscape    = "esc"
+	keyInterrupt = "ctrl+c"
+)
+
+var (
+	spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+	// escapeSequences matches terminal control sequences in the output of the steps
+	escapeSequences = regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]|\x1b\][^\x07]*\x07`)
+	leadingEscape   = regexp.MustCompile(`^(?:\x1b\[[0-9;?]*[a-zA-Z]|\x1b\][^\x07]*\x07)`)
+
+	dim      = color.New(color.Faint).SprintFunc()
+	bold     = color.New(color.Bold).SprintFunc()
+	inverted = color.New(color.ReverseVideo).SprintFunc()
+)
+
+// parseKeys turns the bytes read from the terminal into key names
+func parseKeys(buf []byte) []string {
+	var keys []string
+	for idx := 0; idx < len(buf); idx++ {
+		switch {
+		case buf[idx] == 3:
+			keys = append(keys, keyInterrupt)
+		case buf[idx] == '\r' || buf[idx] == '\n':
+			keys = append(keys, keyEnter)
+		case buf[idx] == 27 && idx+2 < len(buf) && buf[idx+1] == '[':
+			switch buf[idx+2] {
+			case 'A':
+				keys = append(keys, keyUp)
+			case 'B':
+				keys = append(keys, keyDown)
+			}
+			idx += 2
+		case buf[idx] == 27:
+			keys = append(keys, keyEscape)
+		default:
+			keys = append(keys, string(buf[idx]))
+		}
+	}
+
+	return keys
+}
+
+// render returns the lines of the screen. It should be called with the lock held
+func (d *Dashboard) render(width, height int) []string {
+	if d.expanded && d.selected < len(d.steps) {
+		return d.renderLog(d.steps[d.selected], width, height)
+	}
+
+	done := 0
+	for _, step := range d.steps {
+		if isFinished(step.status) {
+			done++
+		}
+	}
+
+	header := fmt.Sprintf("%s  %s  %s  %d/%d steps done",
+		bold(d.workflow),
+		dim("session "+d.sessionID),
+		formatElapsed(d.startedAt, time.Time{}),
+		done,
+		len(d.steps))
+
+	nameWidth := 4
+	for _, step := range d.steps {
+		if len(step.name) > nameWidth {
+			nameWidth = len(step.name)
+		}
+	}
+
+	var body []string
+	selectedLine := 0
+	for idx, step := range d.steps {
+		marker := "  "
+		if idx == d.selected {
+			marker = "> "
+			selectedLine = len(body)
+		}
+
+		line := fmt.Sprintf("%s%s %s  %s  %s",
+			marker,
+			d.glyph(step),
+			pad(step.name, nameWidth),
+			pad(step.status, 9),
+			pad(formatElapsed(step.startedAt, step.endedAt), 8))
+		line += dim(d.details(step))
+		if idx == d.selected {
+			line = inverted(truncate(line, width))
+		}
+		body = append(body, truncate(line, width))
+
+		if step.status == utils.StatusRunning || (idx == d.selected && step.spinner != nil) {
+			for _, output := range tail(step.spinner, tailLines) {
+				body = append(body, dim(truncate("      "+output, width)))
+			}
+		}
+	}
+
+	// keep the selected step on the screen
+	room := height - 3
+	if room < 1 {
+		room = 1
+	}
+	if len(body) > room {
+		start := selectedLine - room/2
+		if start < 0 {
+			start = 0
+		}
+		if start > len(body)-room {
+			start = len(body) - room
+		}
+		body = body[start : start+room]
+	}
+
+	lines := []string{header, ""}
+	lines = append(lines, body...)
+	lines = append(lines, d.footer(width))
+
+	return lines
+}
+
+func (d *Dashboard) renderLog(step *stepState, width, height int) []string {
+	header := fmt.Sprintf("%s  %s", bold(step.name), step.status)
+	if step.spinner != nil {
+		header += dim(fmt.Sprintf("  %s, attempt %d", step.spinner.Kind, step.attempt))
+	}
+
+	if d.log == nil || d.log.spinner != step.spinner {
+		d.log = newOutputLog(step.spinner)
+	}
+	output := d.log.Lines()
+
+	room := height - 3
+	if room < 1 {
+		room = 1
+	}
+
+	// scroll counts lines up from the end of the log
+	maxScroll := len(output) - room
+	if maxScroll < 0 {
+		maxScroll = 0
+	}
+	if d.scroll > maxScroll {
+		d.scroll = maxScroll
+	}
+	end := len(output) - d.scroll
+	start := end - room
+	if start < 0 {
+		start = 0
+	}
+
+	lines := []string{header, ""}
+	for _, line := range output[start:end] {
+		lines = append(lines, truncate(clean(line), width))
+	}
+	if len(output) == 0 {
+		lines = append(lines, dim("No output"))
+	}
+	lines = append(lines, d.footer(width))
+
+	return lines
+}
+
+func (d *Dashboard) footer(width int) string {
+	switch {
+	case d.question != nil:
+		return truncate(color.New(color.FgYellow, color.Bold).Sprintf("Run %s? [y/N]", d.question.step), width)
+	case d.stopping:
+		return truncate(color.YellowString("Stopping..."), width)
+	case d.expanded:
+		return dim(truncate("↑/↓ scroll  esc back  ctrl+c stop", width))
+	default:
+		return dim(truncate("↑/↓ select  enter show log  ctrl+c stop", width))
+	}
+}
+
+func (d *Dashboard) glyph(step *stepState) string {
+	switch step.status {
+	case utils.StatusRunning:
+		frame := int(time.Since(d.startedAt)/refreshInterval) % len(spinnerFrames)
+		return color.CyanString(spinnerFrames[frame])
+	case utils.StatusSuccess:
+		return color.GreenString("✔")
+	case utils.StatusFailed:
+		return color.RedString("✖")
+	case utils.StatusCancelled:
+		return color.YellowString("⊘")
+	case utils.StatusSkipped, utils.StatusDisabled:
+		return dim("-")
+	default:
+		return dim("·")
+	}
+}
+
+// details returns the dependency state of pending steps and the error of failed ones
+func (d *Dashboard) details(step *stepState) string {
+	switch step.status {
+	case utils.StatusPending:
+		if d.question != nil && d.question.step == step.name {
+			return "waiting for confirmation"
+		}
+
+		var waiting []string
+		for _, name := range step.dependsOn {
+			dependency, ok := d.byName[name]
+			if !ok {
+				continue
+			}
+			if dependency.status == utils.StatusFailed || dependency.status == utils.StatusCancelled {
+				return "blocked by " + name
+			}
+			if dependency.status != utils.StatusSuccess && dependency.status != utils.StatusDisabled {
+				waiting = append(waiting, name)
+			}
+		}
+		if len(waiting) > 0 {
+			return "waiting on " + strings.Join(waiting, ", ")
+		}
+		return "ready"
+	case utils.StatusRunning:
+		if step.attempt > 1 {
+			return fmt.Sprintf("attempt %d", step.attempt)
+		}
+	case utils.StatusFailed:
+		return firstLine(step.err)
+	}
+
+	return ""
+}
+
+func isFinished(status string) bool {
+	return status != utils.StatusPending && status != utils.StatusRunning
+}
+
+func tail(spinner *utils.Spinner, n int) []string {
+	if spinner == nil {
+		return nil
+	}
+
+	output := spinner.Output()
+	if len(output) > n {
+		output = output[len(output)-n:]
+	}
+
+	result := make([]string, 0, len(output))
+	for _, line := range output {
+		result = append(result, clean(line))
+	}
+
+	return result
+}
+
+func formatElapsed(start, end time.Time) string {
+	if start.IsZero() {
+		return ""
+	}
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	return end.Sub(start).Round(100 * time.Millisecond).String()
+}
+
+// clean removes control sequences and characters from a line of output
+func clean(line string) string {
+	line = escapeSequences.ReplaceAllString(line, "")
+	// progress bars redraw the line with \r so only the last part is shown
+	if idx := strings.LastIndex(strings.TrimRight(line, "\r"), "\r"); idx >= 0 {
+		line = line[idx+1:]
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return ' '
+		}
+		if r < 32 || r == 127 {
+			return -1
+		}
+		return r
+	}, line)
+}
+
+func firstLine(value string) string {
+	return strings.TrimSpace(strings.SplitN(strings.TrimSpace(value), "\n", 2)[0])
+}
+
+func pad(value string, width int) string {
+	if count := utf8.RuneCountInString(value); count < width {
+		return value + strings.Repeat(" ", width-count)
+	}
+
+	return value
+}
+
+// truncate cuts a line to width visible characters, keeping any color codes intact
+func truncate(line string, width int) string {
+	visible := 0
+	var buf strings.Builder
+	for idx := 0; idx < len(line); {
+		if loc := leadingEscape.FindStringIndex(line[idx:]); loc != nil {
+			buf.WriteString(line[idx : idx+loc[1]])
+			idx += loc[1]
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(line[idx:])
+		if visible < width {
+			buf.WriteRune(r)
+		}
+		visible++
+		idx += size
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package tui
+
+import "errors"
+
+// makeRaw is not supported on this platform. Keys only arrive after enter is pressed
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
+
+// terminalSize returns the default size since it can't be read on this platform
+func terminalSize(fd int) (int, int) {
+	return defaultWidth, defaultHeight
+}
@@ -0,0 +1,42 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package tui
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// makeRaw puts the terminal in raw mode so keys are read as they are pressed.
+// It returns a function to restore the terminal
+func makeRaw(fd int) (func(), error) {
+	original, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := *original
+	// ctrl+c is handled by the dashboard so it can stop the workflow cleanly
+	raw.Lflag &^= unix.ICANON | unix.ECHO | unix.ISIG | unix.IEXTEN
+	raw.Iflag &^= unix.IXON | unix.ICRNL
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+
+	if err = unix.IoctlSetTermios(fd, ioctlSetTermios, &raw); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		_ = unix.IoctlSetTermios(fd, ioctlSetTermios, original)
+	}, nil
+}
+
+// terminalSize returns the width and height of the terminal
+func terminalSize(fd int) (int, int) {
+	size, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil || size.Col == 0 || size.Row == 0 {
+		return defaultWidth, defaultHeight
+	}
+
+	return int(size.Col), int(size.Row)
+}
@@ -0,0 +1,232 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// EnvSessionID is the session ID of the workflow run, passed on to all processes
+	EnvSessionID = "TRACKMAN_SESSION_ID"
+	// EnvWorkflow is the name of the workflow, passed on to all processes
+	EnvWorkflow = "TRACKMAN_WORKFLOW"
+	// EnvStep is the name of the step, passed on to all processes
+	EnvStep = "TRACKMAN_STEP"
+	// EnvAttempt is the attempt of the process, starting at 1
+	EnvAttempt = "TRACKMAN_ATTEMPT"
+	// EnvMetadataPrefix starts the names of metadata exported with export_metadata
+	EnvMetadataPrefix = "TRACKMAN_META_"
+)
+
+var invalidEnvNameChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// StringList is a list of strings that can also be written as a single string in yaml
+type StringList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (l *StringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err == nil {
+		*l = StringList{value}
+		return nil
+	}
+
+	var values []string
+	if err := unmarshal(&values); err != nil {
+		return err
+	}
+	*l = values
+
+	return nil
+}
+
+// readEnvFiles reads the dotenv files in names, in order
+func readEnvFiles(options *WorkflowOptions, names []string) ([]string, error) {
+	var env []string
+	for _, name := range names {
+		fileEnv, err := readEnvFile(options.path(name))
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, fileEnv...)
+	}
+
+	return env, nil
+}
+
+// readEnvFile reads a dotenv file with a KEY=value on each line. Empty lines
+// and lines starting with # are ignored. Lines can start with export and
+// values can be quoted. Double quoted values can have \n, \t, \" and \\ in them
+func readEnvFile(name string) ([]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: invalid line. It should be KEY=value", name, number)
+		}
+
+		value, err := parseEnvValue(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", name, number, err)
+		}
+
+		env = append(env, key+"="+value)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+func parseEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	quote := value[0]
+	if quote != '"' && quote != '\'' {
+		// unquoted values can have a comment after them
+		if idx := strings.Index(value, " #"); idx >= 0 {
+			value = strings.TrimSpace(value[:idx])
+		}
+		return value, nil
+	}
+
+	end := strings.LastIndexByte(value, quote)
+	if end == 0 {
+		return "", fmt.Errorf("unterminated quoted value")
+	}
+	if rest := strings.TrimSpace(value[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected %s after quoted value", rest)
+	}
+
+	value = value[1:end]
+	if quote == '"' {
+		value = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(value)
+	}
+
+	return value, nil
+}
+
+// filterEnv returns the NAME=value entries of env whose name matches one of
+// the patterns in allow. Patterns can have wildcards like AWS_*
+func filterEnv(env []string, allow []string) []string {
+	var filtered []string
+	for _, entry := range env {
+		name := strings.SplitN(entry, "=", 2)[0]
+		for _, pattern := range allow {
+			if matched, _ := path.Match(pattern, name); matched {
+				filtered = append(filtered, entry)
+				break
+			}
+		}
+	}
+
+	return filtered
+}
+
+// MetadataEnvName returns the environment variable a metadata key is exported
+// as. cloud66.com/uuid is exported as TRACKMAN_META_CLOUD66_COM_UUID
+func MetadataEnvName(key string) string {
+	return EnvMetadataPrefix + invalidEnvNameChars.ReplaceAllString(strings.ToUpper(key), "_")
+}
+
+// loadEnvFiles reads the env files of the workflow and its steps
+func (w *Workflow) loadEnvFiles() error {
+	var err error
+	if w.fileEnv, err = readEnvFiles(w.options, w.EnvFile); err != nil {
+		return err
+	}
+
+	for _, step := range w.Steps {
+		if step.fileEnv, err = readEnvFiles(w.options, step.EnvFile); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// environment returns the environment of the processes of the step. When the
+// same variable is set more than once the last one wins, so the order is:
+// Trackman's environment (all of it or only the allowed variables), the
+// exported metadata, the workflow env_file and env, the secrets, and the step
+// env_file and env
+func (s *Step) environment() []string {
+	workflow := s.workflow
+
+	inherit := true
+	allow := workflow.AllowEnv
+	if workflow.InheritEnv != nil {
+		inherit = *workflow.InheritEnv
+	}
+	if s.InheritEnv != nil {
+		inherit = *s.InheritEnv
+	}
+	if s.AllowEnv != nil {
+		allow = s.AllowEnv
+	}
+
+	env := os.Environ()
+	if !inherit {
+		env = filterEnv(env, allow)
+	}
+
+	env = append(env, s.metadataEnv()...)
+	env = append(env, workflow.fileEnv...)
+	env = append(env, workflow.Env...)
+	env = append(env, workflow.secretEnv()...)
+	env = append(env, s.fileEnv...)
+	env = append(env, s.Env...)
+
+	return env
+}
+
+// metadataEnv returns the merged metadata of the step as environment variables
+// if export_metadata is set. Nested values are exported with their path
+func (s *Step) metadataEnv() []string {
+	export := s.workflow.ExportMetadata != nil && *s.workflow.ExportMetadata
+	if s.ExportMetadata != nil {
+		export = *s.ExportMetadata
+	}
+	if !export {
+		return nil
+	}
+
+	var env []string
+	for key, value := range s.MergedMetadata() {
+		env = append(env, MetadataEnvName(key)+"="+value)
+	}
+
+	return env
+}
+
+// contextEnv returns the environment variables that tell a process about its run
+func (s *Spinner) contextEnv() []string {
+	return []string{
+		EnvSessionID + "=" + s.step.workflow.SessionID(),
+		EnvWorkflow + "=" + s.step.workflow.Name,
+		EnvStep + "=" + s.step.Name,
+		EnvAttempt + "=" + strconv.Itoa(s.attempt),
+	}
+}
@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// lookupEnv returns the value of name in env the way a process sees it: the
+// last entry wins
+func lookupEnv(env []string, name string) (string, bool) {
+	value, found := "", false
+	for _, entry := range env {
+		keyValue := strings.SplitN(entry, "=", 2)
+		if keyValue[0] == name && len(keyValue) == 2 {
+			value, found = keyValue[1], true
+		}
+	}
+
+	return value, found
+}
+
+func TestStepEnvironment(t *testing.T) {
+	yes, no := true, false
+
+	tests := []struct {
+		name     string
+		workflow *Workflow
+		step     *Step
+		// expected values by name. An empty value means the variable isn't set
+		expected map[string]string
+	}{
+		{
+			name:     "inherits the environment of trackman",
+			workflow: &Workflow{},
+			step:     &Step{},
+			expected: map[string]string{"TM_TEST_A": "process", "TM_TEST_B": "process"},
+		},
+		{
+			name:     "workflow env_file wins over the process",
+			workflow: &Workflow{fileEnv: []string{"TM_TEST_A=workflow-file"}},
+			step:     &Step{},
+			expected: map[string]string{"TM_TEST_A": "workflow-file", "TM_TEST_B": "process"},
+		},
+		{
+			name: "workflow env wins over the workflow env_file",
+			workflow: &Workflow{
+				fileEnv: []string{"TM_TEST_A=workflow-file"},
+				Env:     []string{"TM_TEST_A=workflow"},
+			},
+			step:     &Step{},
+			expected: map[string]string{"TM_TEST_A": "workflow"},
+		},
+		{
+			name: "secrets win over the workflow env",
+			workflow: &Workflow{
+				Env:     []string{"TM_TEST_A=workflow"},
+				Secrets: []*SecretDefinition{{Name: "TM_TEST_A", value: "secret", loaded: true}},
+			},
+			step:     &Step{},
+			expected: map[string]string{"TM_TEST_A": "secret"},
+		},
+		{
+			name: "step env_file wins over secrets",
+			workflow: &Workflow{
+				Secrets: []*SecretDefinition{{Name: "TM_TEST_A", value: "secret", loaded: true}},
+			},
+			step:     &Step{fileEnv: []string{"TM_TEST_A=step-file"}},
+			expected: map[string]string{"TM_TEST_A": "step-file"},
+		},
+		{
+			name:     "step env wins over everything",
+			workflow: &Workflow{fileEnv: []string{"TM_TEST_A=workflow-file"}, Env: []string{"TM_TEST_A=workflow"}},
+			step:     &Step{fileEnv: []string{"TM_TEST_A=step-file"}, Env: []string{"TM_TEST_A=step"}},
+			expected: map[string]string{"TM_TEST_A": "step", "TM_TEST_B": "process"},
+		},
+		{
+			name:     "inherit_env false only passes allowed variables",
+			workflow: &Workflow{InheritEnv: &no, AllowEnv: []string{"TM_TEST_B"}},
+			step:     &Step{},
+			expected: map[string]string{"TM_TEST_A": "", "TM_TEST_B": "process"},
+		},
+		{
+			name:     "allow_env takes wildcards",
+			workflow: &Workflow{InheritEnv: &no, AllowEnv: []string{"TM_TEST_*"}},
+			step:     &Step{},
+			expected: map[string]string{"TM_TEST_A": "process", "TM_TEST_B": "process"},
+		},
+		{
+			name:     "inherit_env false still passes the workflow env",
+			workflow: &Workflow{InheritEnv: &no, Env: []string{"TM_TEST_C=workflow"}},
+			step:     &Step{},
+			expected: map[string]string{"TM_TEST_A": "", "TM_TEST_C": "workflow"},
+		},
+		{
+			name:     "step inherit_env overrides the workflow",
+			workflow: &Workflow{InheritEnv: &no},
+			step:     &Step{InheritEnv: &yes},
+			expected: map[string]string{"TM_TEST_A": "process"},
+		},
+		{
+			name:     "step allow_env overrides the workflow",
+			workflow: &Workflow{InheritEnv: &no, AllowEnv: []string{"TM_TEST_A"}},
+			step:     &Step{AllowEnv: []string{"TM_TEST_B"}},
+			expected: map[string]string{"TM_TEST_A": "", "TM_TEST_B": "process"},
+		},
+		{
+			name:     "exported metadata loses to the workflow env",
+			workflow: &Workflow{ExportMetadata: &yes, Metadata: Metadata{"region": "eu", "db": map[string]interface{}{"host": "db1"}}, Env: []string{"TRACKMAN_META_REGION=env"}},
+			step:     &Step{},
+			expected: map[string]string{"TRACKMAN_META_REGION": "env", "TRACKMAN_META_DB_HOST": "db1"},
+		},
+		{
+			name:     "step export_metadata overrides the workflow",
+			workflow: &Workflow{ExportMetadata: &yes, Metadata: Metadata{"region": "eu"}},
+			step:     &Step{ExportMetadata: &no},
+			expected: map[string]string{"TRACKMAN_META_REGION": ""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("TM_TEST_A", "process")
+			t.Setenv("TM_TEST_B", "process")
+
+			test.step.workflow = test.workflow
+			env := test.step.environment()
+
+			for name, expected := range test.expected {
+				value, found := lookupEnv(env, name)
+				if expected == "" {
+					if found {
+						t.Errorf("expected %s not to be set, got %s", name, value)
+					}
+					continue
+				}
+				if value != expected {
+					t.Errorf("expected %s to be %s, got %s", name, expected, value)
+				}
+			}
+		})
+	}
+}
+
+func TestReadEnvFileValues(t *testing.T) {
+	tests := map[string]string{
+		``:                         "",
+		`plain`:                    "plain",
+		`plain # comment`:          "plain",
+		`"quoted # not a comment"`: "quoted # not a comment",
+		`'single \n'`:              `single \n`,
+		`"double \n \" \\"`:        "double \n \" \\",
+	}
+
+	for value, expected := range tests {
+		parsed, err := parseEnvValue(value)
+		if err != nil {
+			t.Errorf("%s: %s", value, err)
+			continue
+		}
+		if parsed != expected {
+			t.Errorf("%s: expected %q, got %q", value, expected, parsed)
+		}
+	}
+
+	if _, err := parseEnvValue(`"unterminated`); err == nil {
+		t.Errorf("expected an error for an unterminated value")
+	}
+}
+
+func TestContextEnv(t *testing.T) {
+	spinner := &Spinner{
+		step: Step{
+			Name:     "build",
+			workflow: &Workflow{Name: "deploy", sessionID: "session"},
+		},
+		attempt: 2,
+	}
+
+	env := spinner.contextEnv()
+	for name, expected := range map[string]string{
+		EnvSessionID: "session",
+		EnvWorkflow:  "deploy",
+		EnvStep:      "build",
+		EnvAttempt:   "2",
+	} {
+		if value, _ := lookupEnv(env, name); value != expected {
+			t.Errorf("expected %s to be %s, got %s", name, expected, value)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package utils
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 const (
 	// EventRunRequested run requested
@@ -19,6 +23,36 @@ const (
 	EventRunTimeout = "run.timeout"
 	// EventRunningProbe announces probing
 	EventRunningProbe = "run.probing"
+
+	// EventWorkflowStarted workflow started running
+	EventWorkflowStarted = "workflow.started"
+	// EventWorkflowFinished workflow finished running with all steps successful
+	EventWorkflowFinished = "workflow.finished"
+	// EventWorkflowFailed workflow finished running with errors
+	EventWorkflowFailed = "workflow.failed"
+
+	// EventPreflightStarted preflight checks started
+	EventPreflightStarted = "preflight.started"
+	// EventPreflightPassed all preflight checks passed
+	EventPreflightPassed = "preflight.passed"
+	// EventPreflightFailed a preflight check failed
+	EventPreflightFailed = "preflight.failed"
+
+	// EventStepStarted step started running
+	EventStepStarted = "step.started"
+	// EventStepFinished step finished running
+	EventStepFinished = "step.finished"
+	// EventStepSkipped step was never run because the workflow stopped
+	EventStepSkipped = "step.skipped"
+	// EventStepDisabled step is disabled and was not run
+	EventStepDisabled = "step.disabled"
+	// EventStepCancelled step was cancelled before running
+	EventStepCancelled = "step.cancelled"
+
+	// EventConfirmationAsked user is asked to confirm running a step
+	EventConfirmationAsked = "confirm.asked"
+	// EventConfirmationAnswered user answered a confirmation
+	EventConfirmationAnswered = "confirm.answered"
 )
 
 // Event is a simple event
@@ -27,15 +61,67 @@ type Event struct {
 	Payload Payload
 }
 
-// NewEvent creates a new event
-func NewEvent(spinner *Spinner, name string, extras interface{}) *Event {
-	return &Event{
+// NewEvent creates a new event for a spinner, including the details of its
+// last run
+func NewEvent(spinner *Spinner, name string, err error) *Event {
+	event := newEvent(spinner.step.workflow, name, err)
+	event.Payload.Step = &spinner.step
+	event.Payload.StepName = spinner.step.Name
+	event.Payload.Spinner = spinner
+	event.Payload.setTiming(spinner.startedAt, spinner.endedAt)
+	event.Payload.Attempt = spinner.attempt
+	event.Payload.Signal = spinner.signal
+	event.Payload.TimedOut = spinner.timedOut
+	if spinner.exitCode != nil {
+		exitCode := *spinner.exitCode
+		event.Payload.ExitCode = &exitCode
+	}
+	if spinner.stdout != nil {
+		event.Payload.Stdout = spinner.stdout.Tail(OutputTailSize)
+	}
+	if spinner.stderr != nil {
+		event.Payload.Stderr = spinner.stderr.Tail(OutputTailSize)
+	}
+	if spinner.output != nil {
+		event.Payload.Output = spinner.output.Tail(OutputTailSize)
+		event.Payload.OutputFile = spinner.output.File()
+	}
+
+	return event
+}
+
+// NewStepEvent creates a new event for a step which is not tied to a spinner
+func NewStepEvent(step *Step, name string, err error) *Event {
+	event := newEvent(step.workflow, name, err)
+	event.Payload.Step = step
+	event.Payload.StepName = step.Name
+	event.Payload.QueueWait = step.queueWait
+	event.Payload.setTiming(step.startedAt, step.endedAt)
+
+	return event
+}
+
+// NewWorkflowEvent creates a new event for the workflow
+func NewWorkflowEvent(workflow *Workflow, name string, err error) *Event {
+	event := newEvent(workflow, name, err)
+	event.Payload.setTiming(workflow.startedAt, workflow.endedAt)
+
+	return event
+}
+
+func newEvent(workflow *Workflow, name string, err error) *Event {
+	event := &Event{
 		Name: name,
 		Payload: Payload{
 			EventUUID: uuid.New().String(),
-			Spinner:   spinner,
-			Step:      spinner.step,
-			Extras:    extras,
+			SessionID: workflow.SessionID(),
+			Workflow:  workflow,
+			Timestamp: time.Now(),
 		},
 	}
+
+	event.Payload.setError(err)
+	event.Payload.Error = workflow.Masker().Mask(event.Payload.Error)
+
+	return event
 }
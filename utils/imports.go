@@ -0,0 +1,370 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// importableKeys are the top level attributes an imported file can have
+var importableKeys = map[string]bool{
+	"version":   true,
+	"imports":   true,
+	"metadata":  true,
+	"env":       true,
+	"templates": true,
+	"steps":     true,
+}
+
+// StepTemplate is a step that can be reused by steps with uses
+type StepTemplate struct {
+	Name string `yaml:"name" json:"name"`
+	// With are the arguments of the template and their defaults. Arguments
+	// without a default are required
+	With map[string]interface{} `yaml:"with" json:"with"`
+	Step map[string]interface{} `yaml:"step" json:"step"`
+}
+
+// workflowImporter merges imported files into a workflow
+type workflowImporter struct {
+	// stack is the chain of files being imported, to find cycles
+	stack []string
+	// imported are the files already imported. A file is only imported once
+	imported map[string]bool
+}
+
+// resolveImports returns the workflow in buff with its imports merged into it
+// and the steps that use a template replaced with the template
+func resolveImports(options *WorkflowOptions, buff []byte) ([]byte, error) {
+	var document map[string]interface{}
+	if err := yaml.Unmarshal(buff, &document); err != nil {
+		return nil, err
+	}
+	if document == nil || !needsImports(document) {
+		// plain workflows are parsed as they are
+		return buff, nil
+	}
+
+	importer := &workflowImporter{imported: make(map[string]bool)}
+	if options.File != "" {
+		// so files importing the workflow are found as a cycle
+		root, err := filepath.Abs(options.File)
+		if err != nil {
+			return nil, err
+		}
+		importer.stack = []string{root}
+	}
+
+	document, err := importer.resolve(document, options.path("."))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = expandTemplates(document); err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(document)
+}
+
+// needsImports returns true if the workflow has imports, templates or steps
+// that use a template
+func needsImports(document map[string]interface{}) bool {
+	if document["imports"] != nil || document["templates"] != nil {
+		return true
+	}
+
+	steps, _ := document["steps"].([]interface{})
+	for _, step := range steps {
+		if values, ok := step.(map[interface{}]interface{}); ok && values["uses"] != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolve merges the imports of document into it. Imports are relative to dir
+func (i *workflowImporter) resolve(document map[string]interface{}, dir string) (map[string]interface{}, error) {
+	names, err := importNames(document["imports"])
+	if err != nil {
+		return nil, err
+	}
+	delete(document, "imports")
+
+	merged := make(map[string]interface{})
+	for _, name := range names {
+		file := name
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		if file, err = filepath.Abs(file); err != nil {
+			return nil, err
+		}
+
+		for idx, importing := range i.stack {
+			if importing == file {
+				cycle := append(append([]string{}, i.stack[idx:]...), file)
+				return nil, fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> "))
+			}
+		}
+		if i.imported[file] {
+			continue
+		}
+		i.imported[file] = true
+
+		imported, err := readImport(file)
+		if err != nil {
+			return nil, err
+		}
+
+		i.stack = append(i.stack, file)
+		imported, err = i.resolve(imported, filepath.Dir(file))
+		if err != nil {
+			return nil, err
+		}
+		i.stack = i.stack[:len(i.stack)-1]
+
+		if merged, err = mergeImport(merged, imported); err != nil {
+			return nil, fmt.Errorf("invalid import %s: %s", name, err)
+		}
+	}
+
+	return mergeImport(merged, document)
+}
+
+func importNames(value interface{}) ([]string, error) {
+	switch typed := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{typed}, nil
+	case []interface{}:
+		names := make([]string, 0, len(typed))
+		for _, name := range typed {
+			text, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid import %v. It should be a file name", name)
+			}
+			names = append(names, text)
+		}
+		return names, nil
+	default:
+		return nil, fmt.Errorf("imports should be a file name or a list of them")
+	}
+}
+
+// readImport reads an imported file and checks it only has attributes that
+// can be imported
+func readImport(file string) (map[string]interface{}, error) {
+	buff, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]interface{}
+	if err = yaml.Unmarshal(buff, &document); err != nil {
+		return nil, fmt.Errorf("invalid import %s: %s", file, err)
+	}
+
+	var invalid []string
+	for key := range document {
+		if !importableKeys[key] {
+			invalid = append(invalid, key)
+		}
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return nil, fmt.Errorf("%s can't be imported from %s. Only imports, metadata, env, templates and steps can", strings.Join(invalid, ", "), file)
+	}
+	if version, ok := document["version"]; ok && fmt.Sprint(version) != "1" {
+		return nil, fmt.Errorf("invalid workflow version in %s", file)
+	}
+	delete(document, "version")
+
+	return document, nil
+}
+
+// mergeImport merges document into base. Steps and env are added after the
+// ones in base, metadata is merged key by key and templates replace the ones
+// in base with the same name. Anything else in document replaces base
+func mergeImport(base, document map[string]interface{}) (map[string]interface{}, error) {
+	merged := make(map[string]interface{}, len(base)+len(document))
+	for key, value := range base {
+		merged[key] = value
+	}
+
+	for key, value := range document {
+		switch key {
+		case "steps", "env":
+			list, err := importList(key, value)
+			if err != nil {
+				return nil, err
+			}
+			baseList, _ := merged[key].([]interface{})
+			merged[key] = append(append([]interface{}{}, baseList...), list...)
+		case "metadata":
+			metadata, ok := normalizeMetadataValue(value).(map[string]interface{})
+			if value != nil && !ok {
+				return nil, fmt.Errorf("metadata should be a map")
+			}
+			baseMetadata, _ := merged[key].(map[string]interface{})
+			merged[key] = map[string]interface{}(mergeMetadata(baseMetadata, metadata))
+		case "templates":
+			list, err := importList(key, value)
+			if err != nil {
+				return nil, err
+			}
+			baseList, _ := merged[key].([]interface{})
+			merged[key] = mergeTemplates(baseList, list)
+		default:
+			merged[key] = value
+		}
+	}
+
+	return merged, nil
+}
+
+func importList(key string, value interface{}) ([]interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s should be a list", key)
+	}
+
+	return list, nil
+}
+
+// mergeTemplates adds templates to base, replacing the ones with the same name
+func mergeTemplates(base, templates []interface{}) []interface{} {
+	merged := append([]interface{}{}, base...)
+	for _, template := range templates {
+		name := templateName(template)
+		replaced := false
+		for idx, existing := range merged {
+			if name != "" && templateName(existing) == name {
+				merged[idx] = template
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, template)
+		}
+	}
+
+	return merged
+}
+
+func templateName(template interface{}) string {
+	if values, ok := template.(map[interface{}]interface{}); ok {
+		if name, ok := values["name"].(string); ok {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// expandTemplates replaces the steps with uses with the step of their
+// template. Attributes of the step win over the ones of the template
+func expandTemplates(document map[string]interface{}) error {
+	list, err := importList("templates", document["templates"])
+	if err != nil {
+		return err
+	}
+	delete(document, "templates")
+
+	buff, err := yaml.Marshal(list)
+	if err != nil {
+		return err
+	}
+	var definitions []*StepTemplate
+	if err = yaml.Unmarshal(buff, &definitions); err != nil {
+		return fmt.Errorf("invalid templates: %s", err)
+	}
+
+	templates := make(map[string]*StepTemplate, len(definitions))
+	for _, template := range definitions {
+		if template.Name == "" {
+			return fmt.Errorf("templates should have a name")
+		}
+		if _, ok := template.Step["uses"]; ok {
+			return fmt.Errorf("template %s can't use another template", template.Name)
+		}
+		templates[template.Name] = template
+	}
+
+	steps, err := importList("steps", document["steps"])
+	if err != nil {
+		return err
+	}
+	for idx, raw := range steps {
+		step, ok := raw.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		uses, ok := step["uses"]
+		if !ok {
+			continue
+		}
+
+		template, ok := templates[fmt.Sprint(uses)]
+		if !ok {
+			return fmt.Errorf("step %v uses an unknown template %v", step["name"], uses)
+		}
+		if steps[idx], err = template.expand(step); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expand returns the template step with the attributes of step and the
+// arguments given in its with
+func (t *StepTemplate) expand(step map[interface{}]interface{}) (map[interface{}]interface{}, error) {
+	var args map[interface{}]interface{}
+	if step["with"] != nil {
+		var ok bool
+		if args, ok = step["with"].(map[interface{}]interface{}); !ok {
+			return nil, fmt.Errorf("with of step %v should be a map", step["name"])
+		}
+	}
+
+	for key := range args {
+		if _, ok := t.With[fmt.Sprint(key)]; !ok {
+			return nil, fmt.Errorf("step %v gives an unknown argument %v to template %s", step["name"], key, t.Name)
+		}
+	}
+
+	with := make(map[string]interface{}, len(t.With))
+	for key, defaultValue := range t.With {
+		value, given := args[key]
+		if !given || value == nil {
+			if defaultValue == nil {
+				return nil, fmt.Errorf("step %v should give argument %s to template %s", step["name"], key, t.Name)
+			}
+			value = defaultValue
+		}
+		with[key] = normalizeMetadataValue(value)
+	}
+
+	expanded := make(map[interface{}]interface{}, len(t.Step)+len(step))
+	for key, value := range t.Step {
+		expanded[key] = value
+	}
+	for key, value := range step {
+		expanded[key] = value
+	}
+	expanded["with"] = with
+
+	return expanded, nil
+}
@@ -0,0 +1,303 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// writeFiles writes the files by name under dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// loadFiles writes the files to dir and loads workflow.yml
+func loadFiles(t *testing.T, dir string, files map[string]string) (*Workflow, error) {
+	t.Helper()
+
+	viper.Set("log-type", "discard")
+	viper.Set("log-level", "info")
+	viper.Set("log-format", "text")
+
+	writeFiles(t, dir, files)
+
+	file := filepath.Join(dir, "workflow.yml")
+	options := &WorkflowOptions{
+		Notifier:    func(context.Context, *logrus.Logger, *Event) error { return nil },
+		Concurrency: 1,
+		Timeout:     time.Second,
+		BaseDir:     dir,
+		File:        file,
+	}
+
+	return LoadWorkflowFromBytes(context.Background(), options, []byte(files["workflow.yml"]))
+}
+
+func stepNames(workflow *Workflow) []string {
+	var names []string
+	for _, step := range workflow.Steps {
+		names = append(names, step.Name)
+	}
+
+	return names
+}
+
+func TestImports(t *testing.T) {
+	workflow, err := loadFiles(t, t.TempDir(), map[string]string{
+		"workflow.yml": `
+version: 1
+imports: [lib/build.yml, lib/test.yml]
+metadata:
+  region: eu
+env: ["LEVEL=workflow"]
+steps:
+  - name: deploy
+    command: ./deploy.sh
+`,
+		// imports are relative to the file importing them
+		"lib/build.yml": `
+imports: ../shared/login.yml
+metadata:
+  region: us
+  team: ops
+env: ["LEVEL=build"]
+steps:
+  - name: build
+    command: make
+`,
+		"lib/test.yml": `
+imports: ../shared/login.yml
+steps:
+  - name: test
+    command: make test
+`,
+		"shared/login.yml": `
+version: 1
+steps:
+  - name: login
+    command: ./login.sh
+`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// login is imported by both build.yml and test.yml but only added once
+	if names := strings.Join(stepNames(workflow), ","); names != "login,build,test,deploy" {
+		t.Errorf("unexpected steps %s", names)
+	}
+	if region := workflow.Metadata.String("region"); region != "eu" {
+		t.Errorf("expected the workflow metadata to win, got %s", region)
+	}
+	if team := workflow.Metadata.String("team"); team != "ops" {
+		t.Errorf("expected imported metadata, got %s", team)
+	}
+	if env := strings.Join(workflow.Env, ","); env != "LEVEL=build,LEVEL=workflow" {
+		t.Errorf("expected the env of the workflow after the imported one, got %s", env)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		expected string
+	}{
+		{
+			name: "cycle",
+			files: map[string]string{
+				"workflow.yml": "version: 1\nimports: a.yml\nsteps: []\n",
+				"a.yml":        "imports: b.yml\n",
+				"b.yml":        "imports: a.yml\n",
+			},
+			expected: "import cycle: %[1]s/a.yml -> %[1]s/b.yml -> %[1]s/a.yml",
+		},
+		{
+			name: "cycle through the workflow",
+			files: map[string]string{
+				"workflow.yml": "version: 1\nname: deploy\nimports: a.yml\nsteps: []\n",
+				"a.yml":        "imports: workflow.yml\n",
+			},
+			expected: "import cycle: %[1]s/workflow.yml -> %[1]s/a.yml -> %[1]s/workflow.yml",
+		},
+		{
+			name: "not importable",
+			files: map[string]string{
+				"workflow.yml": "version: 1\nimports: a.yml\nsteps: []\n",
+				"a.yml":        "name: shared\nversion: 1\nsteps: []\n",
+			},
+			expected: "name can't be imported from %[1]s/a.yml",
+		},
+		{
+			name: "missing",
+			files: map[string]string{
+				"workflow.yml": "version: 1\nimports: a.yml\nsteps: []\n",
+			},
+			expected: "%[1]s/a.yml: no such file or directory",
+		},
+		{
+			name: "version",
+			files: map[string]string{
+				"workflow.yml": "version: 1\nimports: a.yml\nsteps: []\n",
+				"a.yml":        "version: 2\n",
+			},
+			expected: "invalid workflow version in %[1]s/a.yml",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			_, err := loadFiles(t, dir, test.files)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if expected := fmt.Sprintf(test.expected, dir); !strings.Contains(err.Error(), expected) {
+				t.Errorf("expected %q in %q", expected, err)
+			}
+		})
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	workflow, err := loadFiles(t, t.TempDir(), map[string]string{
+		"workflow.yml": `
+version: 1
+imports: shared.yml
+templates:
+  # replaces the imported template
+  - name: notify
+    with:
+      channel: general
+      message:
+    step:
+      command: "notify.sh {{ .With.channel }} {{ .With.message }}"
+steps:
+  - name: tell-ops
+    uses: notify
+    with:
+      channel: ops
+      message: deployed
+  - name: tell-all
+    uses: notify
+    with:
+      message: done
+`,
+		"shared.yml": `
+templates:
+  - name: notify
+    with:
+      channel:
+    step:
+      command: "old-notify.sh {{ .With.channel }}"
+      continue_on_fail: true
+`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(workflow.Steps) != 2 {
+		t.Fatalf("expected 2 steps, got %v", stepNames(workflow))
+	}
+	ops, all := workflow.Steps[0], workflow.Steps[1]
+	if ops.Command != "notify.sh {{ .With.channel }} {{ .With.message }}" {
+		t.Errorf("expected the template of the workflow, got %s", ops.Command)
+	}
+	if ops.With.String("channel") != "ops" || ops.With.String("message") != "deployed" {
+		t.Errorf("unexpected arguments %v", ops.With)
+	}
+	// defaults are used for missing arguments
+	if all.With.String("channel") != "general" || all.With.String("message") != "done" {
+		t.Errorf("unexpected arguments %v", all.With)
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	template := `
+version: 1
+templates:
+  - name: notify
+    with:
+      channel: general
+      message:
+    step:
+      command: "notify.sh {{ .With.channel }} {{ .With.message }}"
+steps:
+  - name: tell
+`
+	tests := []struct {
+		name     string
+		step     string
+		expected string
+	}{
+		{
+			name:     "missing argument",
+			step:     "    uses: notify\n    with:\n      channel: ops\n",
+			expected: "step tell should give argument message to template notify",
+		},
+		{
+			name:     "unknown argument",
+			step:     "    uses: notify\n    with:\n      message: hi\n      color: red\n",
+			expected: "step tell gives an unknown argument color to template notify",
+		},
+		{
+			name:     "unknown template",
+			step:     "    uses: alert\n",
+			expected: "step tell uses an unknown template alert",
+		},
+		{
+			name:     "with is not a map",
+			step:     "    uses: notify\n    with: [hi]\n",
+			expected: "with of step tell should be a map",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadFiles(t, t.TempDir(), map[string]string{"workflow.yml": template + test.step})
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("expected %q, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestMergeTemplates(t *testing.T) {
+	template := func(name, command string) interface{} {
+		return map[interface{}]interface{}{"name": name, "step": map[interface{}]interface{}{"command": command}}
+	}
+
+	merged := mergeTemplates(
+		[]interface{}{template("build", "make"), template("notify", "old")},
+		[]interface{}{template("notify", "new"), template("test", "make test")},
+	)
+
+	var result []string
+	for _, item := range merged {
+		values := item.(map[interface{}]interface{})
+		result = append(result, templateName(item)+"="+values["step"].(map[interface{}]interface{})["command"].(string))
+	}
+	if joined := strings.Join(result, ","); joined != "build=make,notify=new,test=make test" {
+		t.Errorf("unexpected templates %s", joined)
+	}
+}
@@ -1,10 +1,8 @@
 package utils
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"html/template"
 	"io/ioutil"
 	"os"
 	"sync"
@@ -63,18 +61,7 @@ func NewLoggingContext(workflow *Workflow, step *Step) *LoggingContext {
 
 // Parse parses the given value within this context.
 func (l *LoggingContext) parse(value string) (string, error) {
-	temp, err := template.New("filename").Parse(value)
-	if err != nil {
-		return "", err
-	}
-
-	var tpl bytes.Buffer
-	err = temp.Execute(&tpl, l)
-	if err != nil {
-		return "", err
-	}
-
-	return tpl.String(), nil
+	return renderTemplate("filename", value, l)
 }
 
 // DefaultLogDefinition returns a LogDefintion based on the given base
@@ -154,5 +141,9 @@ func NewLogger(baseDefinition *LogDefinition, loggingContext *LoggingContext) (*
 		return nil, fmt.Errorf("invalid log format %s", definition.Format)
 	}
 
+	if loggingContext != nil && loggingContext.Workflow != nil && loggingContext.Workflow.masker != nil {
+		logger.AddHook(&maskHook{masker: loggingContext.Workflow.masker})
+	}
+
 	return logger, nil
 }
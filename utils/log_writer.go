@@ -1,8 +1,12 @@
 package utils
 
 import (
+	"bytes"
 	"context"
-	"strings"
+	"fmt"
+	"io"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/sirupsen/logrus"
 )
@@ -10,33 +14,211 @@ import (
 const (
 	// FldStep is a logger field
 	FldStep = "Step"
+
+	// MaxLineLength is the longest line a LogWriter emits. Longer lines are split
+	MaxLineLength = 64 * 1024
+	// logQueueSize is the number of writes waiting to be logged before the process is slowed down
+	logQueueSize = 256
 )
 
 // LogWriter implements io.Writer so it can be used to dump a process output
-// but links it to logrus
+// but links it to logrus. Output is split into lines, even when a line comes
+// in more than one write, and logged in the background. Lines that aren't
+// text are not logged but counted and reported as binary output.
+//
+// Close must be called once nothing else is written: an unfinished last line
+// is only logged then, and Close waits for all lines to be logged. A LogWriter
+// that is never closed can lose the end of the output
 type LogWriter struct {
 	entry   *logrus.Entry
 	level   logrus.Level
 	spinner *Spinner
+	buffer  *OutputBuffer
+	// output collects the lines of both stdout and stderr
+	output *OutputBuffer
+	// silent lines are buffered but not logged
+	silent bool
+
+	partial  []byte
+	carriage bool
+	// binaryBytes is the size of the binary lines not reported yet
+	binaryBytes int
+	closed      bool
+	queue       chan []string
+	done        chan struct{}
+	startOnce   sync.Once
+	lock        sync.Mutex
 }
 
 // Write implements io.Writer
 func (l *LogWriter) Write(b []byte) (int, error) {
-	n := len(b)
-	if n > 0 && b[n-1] == '\n' {
-		b = b[:n-1]
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.closed {
+		return 0, io.ErrClosedPipe
 	}
+	l.start()
 
-	// we want each line to show on its own
-	for _, line := range strings.Split(string(b), "\n") {
-		if l.spinner != nil {
-			l.entry.WithField(FldStep, l.spinner.Name).Log(l.level, line)
+	var lines []string
+	data := b
+	for len(data) > 0 {
+		idx := bytes.IndexAny(data, "\r\n")
+		if idx < 0 {
+			lines = l.appendPartial(lines, data)
+			break
+		}
+
+		lines = l.appendPartial(lines, data[:idx])
+		if data[idx] == '\n' {
+			lines = l.endLine(lines)
 		} else {
-			l.entry.Log(l.level, line)
+			// progress bars redraw the line with \r. Only the last version is kept
+			l.carriage = true
 		}
+
+		data = data[idx+1:]
+	}
+
+	if len(lines) > 0 {
+		// this blocks when the queue is full which slows the process down
+		l.queue <- lines
+	}
+
+	return len(b), nil
+}
+
+// Close logs any unfinished line and waits for all lines to be logged
+func (l *LogWriter) Close() error {
+	l.lock.Lock()
+	if l.closed {
+		l.lock.Unlock()
+		return nil
+	}
+	l.start()
+	l.closed = true
+
+	var lines []string
+	if len(l.partial) > 0 {
+		lines = l.endLine(lines)
 	}
+	lines = l.reportBinary(lines)
+	if len(lines) > 0 {
+		l.queue <- lines
+	}
+	close(l.queue)
+	l.lock.Unlock()
+
+	<-l.done
+
+	return nil
+}
+
+// start starts logging in the background. It should be called with the lock held
+func (l *LogWriter) start() {
+	l.startOnce.Do(func() {
+		l.queue = make(chan []string, logQueueSize)
+		l.done = make(chan struct{})
+
+		go l.emit()
+	})
+}
+
+func (l *LogWriter) emit() {
+	defer close(l.done)
+
+	entry := l.entry
+	if l.spinner != nil {
+		entry = entry.WithField(FldStep, l.spinner.Name)
+	}
+
+	var masker *Masker
+	if l.spinner != nil {
+		masker = l.spinner.step.workflow.Masker()
+	}
+
+	for lines := range l.queue {
+		// formatting lines nobody sees is the most expensive part of chatty processes
+		enabled := !l.silent && entry.Logger.IsLevelEnabled(l.level)
+
+		for _, line := range lines {
+			// buffered lines end up in events and reports so they are masked too
+			line = masker.Mask(line)
+			if l.buffer != nil {
+				l.buffer.Add(line)
+			}
+			if l.output != nil {
+				l.output.Add(line)
+			}
+
+			if enabled {
+				entry.Log(l.level, line)
+			}
+		}
+	}
+}
+
+// appendPartial adds data to the unfinished line, splitting it if it gets too long
+func (l *LogWriter) appendPartial(lines []string, data []byte) []string {
+	if len(data) == 0 {
+		return lines
+	}
+
+	if l.carriage {
+		l.partial = l.partial[:0]
+		l.carriage = false
+	}
+
+	l.partial = append(l.partial, data...)
+	for len(l.partial) > MaxLineLength {
+		// don't cut a multi byte character in half
+		cut := MaxLineLength
+		for cut > 0 && !utf8.RuneStart(l.partial[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			cut = MaxLineLength
+		}
+
+		lines = l.addLine(lines, l.partial[:cut])
+		l.partial = append(l.partial[:0], l.partial[cut:]...)
+	}
+
+	return lines
+}
+
+// endLine finishes the unfinished line
+func (l *LogWriter) endLine(lines []string) []string {
+	lines = l.addLine(lines, l.partial)
+	l.partial = l.partial[:0]
+	l.carriage = false
+
+	return lines
+}
+
+// addLine adds a finished line. Binary lines are counted and reported
+// together, as a single line, once the next text line comes or the writer
+// is closed
+func (l *LogWriter) addLine(lines []string, line []byte) []string {
+	if bytes.IndexByte(line, 0) >= 0 || !utf8.Valid(line) {
+		l.binaryBytes += len(line)
+		return lines
+	}
+
+	lines = l.reportBinary(lines)
+	return append(lines, string(line))
+}
+
+// reportBinary adds a line for the binary lines not reported yet, if any
+func (l *LogWriter) reportBinary(lines []string) []string {
+	if l.binaryBytes == 0 {
+		return lines
+	}
+
+	lines = append(lines, fmt.Sprintf("Suppressed %d bytes of binary output", l.binaryBytes))
+	l.binaryBytes = 0
 
-	return n, nil
+	return lines
 }
 
 // NewLogWriter creates a new LogWriter
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestLogWriter(level logrus.Level) *LogWriter {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Level = logrus.InfoLevel
+
+	writer := NewLogWriter(context.Background(), logger, level)
+	writer.buffer = NewOutputBuffer(100)
+
+	return writer
+}
+
+func TestLogWriterLines(t *testing.T) {
+	writer := newTestLogWriter(logrus.InfoLevel)
+	for _, write := range []string{"one\ntw", "o\n", "50%\r100%\nthree"} {
+		writer.Write([]byte(write))
+	}
+	writer.Close()
+
+	expected := []string{"one", "two", "100%", "three"}
+	if lines := writer.buffer.Lines(); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %v, got %v", expected, lines)
+	}
+}
+
+func TestLogWriterBinary(t *testing.T) {
+	writer := newTestLogWriter(logrus.InfoLevel)
+	writer.Write([]byte("before\n\x00\x01\n\xff\xfe\nafter\n\x00"))
+	writer.Close()
+
+	expected := []string{
+		"before",
+		"Suppressed 4 bytes of binary output",
+		"after",
+		"Suppressed 1 bytes of binary output",
+	}
+	if lines := writer.buffer.Lines(); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %v, got %v", expected, lines)
+	}
+}
+
+// directLogWriter logs each line as soon as it's written, the way LogWriter
+// used to, to compare against
+type directLogWriter struct {
+	entry *logrus.Entry
+	level logrus.Level
+}
+
+func (d *directLogWriter) Write(b []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		d.entry.WithField(FldStep, "bench").Log(d.level, line)
+	}
+
+	return len(b), nil
+}
+
+func BenchmarkLogWriter(b *testing.B) {
+	chunk := []byte(strings.Repeat("a line of output from a chatty process\n", 100))
+
+	for _, level := range []logrus.Level{logrus.InfoLevel, logrus.DebugLevel} {
+		b.Run("buffered/"+level.String(), func(b *testing.B) {
+			writer := newTestLogWriter(level)
+			writer.buffer = nil
+			b.SetBytes(int64(len(chunk)))
+			for i := 0; i < b.N; i++ {
+				writer.Write(chunk)
+			}
+			writer.Close()
+		})
+
+		b.Run("direct/"+level.String(), func(b *testing.B) {
+			logger := logrus.New()
+			logger.Out = ioutil.Discard
+			logger.Level = logrus.InfoLevel
+			writer := &directLogWriter{entry: logrus.NewEntry(logger), level: level}
+			b.SetBytes(int64(len(chunk)))
+			for i := 0; i < b.N; i++ {
+				writer.Write(chunk)
+			}
+		})
+	}
+}
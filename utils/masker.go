@@ -0,0 +1,402 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// MaskedValue replaces secrets in logs, events and reports
+	MaskedValue = "***"
+
+	// minDetectedSecretLength is the shortest value masked for names that look
+	// like secrets. Shorter values would mask too much of the output
+	minDetectedSecretLength = 4
+)
+
+var (
+	// secretNameSuffixes are the endings of environment variable and metadata
+	// names that are treated as secrets without being declared
+	secretNameSuffixes = []string{"TOKEN", "PASSWORD", "PASSWD", "PASS", "SECRET", "API_KEY", "APIKEY", "ACCESS_KEY", "PRIVATE_KEY", "CREDENTIALS"}
+
+	secretNameSeparators = regexp.MustCompile(`[^A-Z0-9]+`)
+)
+
+// Masker replaces secret values in text with MaskedValue
+type Masker struct {
+	values   map[string]bool
+	replacer *strings.Replacer
+	// longest is the length of the longest value masked
+	longest int
+	lock    sync.RWMutex
+}
+
+// NewMasker creates a new Masker with no secrets
+func NewMasker() *Masker {
+	return &Masker{
+		values: make(map[string]bool),
+	}
+}
+
+// IsSecretName returns true if an environment variable or metadata key
+// with this name looks like it holds a secret, like GITHUB_TOKEN or db.password
+func IsSecretName(name string) bool {
+	normalized := strings.Trim(secretNameSeparators.ReplaceAllString(strings.ToUpper(name), "_"), "_")
+	for _, suffix := range secretNameSuffixes {
+		if normalized == suffix || strings.HasSuffix(normalized, "_"+suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Add adds a secret. Its base64 forms, its JSON escaped form and each of its
+// lines (for secrets with more than one line) are masked as well
+func (m *Masker) Add(secret string) {
+	if secret == "" {
+		return
+	}
+
+	variants := []string{
+		secret,
+		base64.StdEncoding.EncodeToString([]byte(secret)),
+		base64.RawStdEncoding.EncodeToString([]byte(secret)),
+		base64.URLEncoding.EncodeToString([]byte(secret)),
+		base64.RawURLEncoding.EncodeToString([]byte(secret)),
+	}
+	if escaped, err := json.Marshal(secret); err == nil {
+		variants = append(variants, strings.Trim(string(escaped), `"`))
+	}
+	// output is masked line by line so each line of the secret is a secret too
+	for _, line := range strings.Split(secret, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) >= minDetectedSecretLength {
+			variants = append(variants, line)
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	changed := false
+	for _, variant := range variants {
+		if variant != "" && !m.values[variant] {
+			m.values[variant] = true
+			changed = true
+		}
+	}
+
+	if changed {
+		m.build()
+	}
+}
+
+// addDetected adds value if name looks like a secret and the value is long enough
+func (m *Masker) addDetected(name, value string) {
+	if len(value) >= minDetectedSecretLength && IsSecretName(name) {
+		m.Add(value)
+	}
+}
+
+// addEnvironment adds the values of NAME=value entries that are declared
+// as secrets or look like one
+func (m *Masker) addEnvironment(env []string, declared map[string]bool) {
+	for _, entry := range env {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		if declared[parts[0]] {
+			m.Add(parts[1])
+		} else {
+			m.addDetected(parts[0], parts[1])
+		}
+	}
+}
+
+// addMetadata adds the metadata values that are declared as secrets or look
+// like one. Nested values are declared with their path, like db.password, or
+// the key of the map holding them
+func (m *Masker) addMetadata(metadata Metadata, declared map[string]bool) {
+	metadata.walk(func(path string, value interface{}) {
+		text := metadataString(value)
+		key := path[strings.LastIndex(path, ".")+1:]
+		if declared[path] || declared[key] {
+			m.Add(text)
+		} else {
+			m.addDetected(key, text)
+		}
+	})
+}
+
+// Mask replaces all secrets in value
+func (m *Masker) Mask(value string) string {
+	if m == nil {
+		return value
+	}
+
+	m.lock.RLock()
+	replacer := m.replacer
+	m.lock.RUnlock()
+
+	if replacer == nil {
+		return value
+	}
+
+	return replacer.Replace(value)
+}
+
+// MaskMap returns a copy of values with all secrets replaced
+func (m *Masker) MaskMap(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+
+	masked := make(map[string]string, len(values))
+	for key, value := range values {
+		masked[key] = m.Mask(value)
+	}
+
+	return masked
+}
+
+// MaskMetadata returns a copy of metadata with all secrets replaced
+func (m *Masker) MaskMetadata(metadata Metadata) Metadata {
+	if metadata == nil {
+		return nil
+	}
+
+	masked := make(Metadata, len(metadata))
+	for key, value := range metadata {
+		masked[key], _ = transformStrings(value, func(text string) (string, error) {
+			return m.Mask(text), nil
+		})
+	}
+
+	return masked
+}
+
+// MaskBytes replaces all secrets in value
+func (m *Masker) MaskBytes(value []byte) []byte {
+	if m == nil || !m.hasSecrets() {
+		return value
+	}
+
+	return []byte(m.Mask(string(value)))
+}
+
+func (m *Masker) hasSecrets() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.replacer != nil
+}
+
+// build creates the replacer. It should be called with the lock held
+func (m *Masker) build() {
+	values := make([]string, 0, len(m.values))
+	for value := range m.values {
+		values = append(values, value)
+	}
+
+	// longer values first so a secret that contains another is masked whole
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+
+	pairs := make([]string, 0, len(values)*2)
+	for _, value := range values {
+		pairs = append(pairs, value, MaskedValue)
+	}
+
+	m.replacer = strings.NewReplacer(pairs...)
+	m.longest = len(values[0])
+}
+
+// flushPoint returns how much of a partial line can be masked and written
+// before more output comes in. The rest could hold the start of a secret
+func (m *Masker) flushPoint(data []byte) int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.longest == 0 {
+		return len(data)
+	}
+
+	// a secret that starts in the last longest-1 bytes isn't complete yet
+	end := len(data) - (m.longest - 1)
+	// and one that starts before end shouldn't be cut in two
+	for moved := true; moved && end > 0; {
+		moved = false
+		for value := range m.values {
+			start := end - len(value) + 1
+			if start < 0 {
+				start = 0
+			}
+			if idx := bytes.Index(data[start:], []byte(value)); idx >= 0 && start+idx < end {
+				end = start + idx
+				moved = true
+			}
+		}
+	}
+
+	if end < 0 {
+		return 0
+	}
+	return end
+}
+
+// maskHook masks secrets in all log messages
+type maskHook struct {
+	masker *Masker
+}
+
+// Levels implements logrus.Hook
+func (h *maskHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook
+func (h *maskHook) Fire(entry *logrus.Entry) error {
+	entry.Message = h.masker.Mask(entry.Message)
+
+	// the fields are shared with other entries so they are masked in a copy
+	var data logrus.Fields
+	for key, value := range entry.Data {
+		var text string
+		switch value := value.(type) {
+		case string:
+			text = value
+		case error:
+			text = value.Error()
+		default:
+			continue
+		}
+
+		if masked := h.masker.Mask(text); masked != text {
+			if data == nil {
+				data = make(logrus.Fields, len(entry.Data))
+				for key, value := range entry.Data {
+					data[key] = value
+				}
+			}
+			data[key] = masked
+		}
+	}
+	if data != nil {
+		entry.Data = data
+	}
+
+	return nil
+}
+
+// maskWriter masks secrets in output written to a file. Output is masked a
+// line at a time so secrets written in more than one write are masked too.
+// Lines longer than MaxLineLength are written in parts that don't cut a secret
+type maskWriter struct {
+	masker  *Masker
+	file    io.WriteCloser
+	pending []byte
+}
+
+func newMaskWriter(masker *Masker, file io.WriteCloser) io.WriteCloser {
+	if masker == nil {
+		return file
+	}
+
+	return &maskWriter{masker: masker, file: file}
+}
+
+// Write implements io.Writer
+func (w *maskWriter) Write(b []byte) (int, error) {
+	w.pending = append(w.pending, b...)
+
+	idx := bytes.LastIndexByte(w.pending, '\n')
+	if idx < 0 && len(w.pending) <= MaxLineLength {
+		return len(b), nil
+	}
+
+	end := idx + 1
+	if idx < 0 {
+		end = w.masker.flushPoint(w.pending)
+		if end == 0 {
+			return len(b), nil
+		}
+	}
+
+	if _, err := w.file.Write(w.masker.MaskBytes(w.pending[:end])); err != nil {
+		return 0, err
+	}
+	w.pending = append(w.pending[:0], w.pending[end:]...)
+
+	return len(b), nil
+}
+
+// Close writes what's left and closes the file
+func (w *maskWriter) Close() error {
+	if len(w.pending) > 0 {
+		if _, err := w.file.Write(w.masker.MaskBytes(w.pending)); err != nil {
+			_ = w.file.Close()
+			return err
+		}
+		w.pending = nil
+	}
+
+	return w.file.Close()
+}
+
+// declaredSecrets returns the names in secrets as a set
+func declaredSecrets(secrets []string) map[string]bool {
+	declared := make(map[string]bool, len(secrets))
+	for _, name := range secrets {
+		declared[name] = true
+	}
+
+	return declared
+}
+
+// newWorkflowMasker creates the masker of a workflow from its declared secrets,
+// the environment of Trackman and its metadata
+func newWorkflowMasker(workflow *Workflow) *Masker {
+	masker := NewMasker()
+	declared := declaredSecrets(workflow.secretNames())
+
+	masker.addEnvironment(os.Environ(), declared)
+	masker.addEnvironment(workflow.fileEnv, declared)
+	masker.addEnvironment(workflow.Env, declared)
+	masker.addMetadata(workflow.Metadata, declared)
+	for name, value := range workflow.params {
+		if declared[name] {
+			masker.Add(fmt.Sprint(value))
+		} else {
+			masker.addDetected(name, fmt.Sprint(value))
+		}
+	}
+	for _, step := range workflow.Steps {
+		masker.addStep(step, declared)
+	}
+
+	return masker
+}
+
+// addStep adds the secrets in the environment and metadata of a step
+func (m *Masker) addStep(step *Step, declared map[string]bool) {
+	m.addEnvironment(step.fileEnv, declared)
+	m.addEnvironment(step.Env, declared)
+	m.addMetadata(step.Metadata, declared)
+}
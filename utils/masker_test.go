@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+type closeBuffer struct {
+	bytes.Buffer
+}
+
+func (c *closeBuffer) Close() error {
+	return nil
+}
+
+func TestMaskHook(t *testing.T) {
+	masker := NewMasker()
+	masker.Add("hunter22")
+
+	buf := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.Out = buf
+	logger.Formatter = &logrus.TextFormatter{DisableTimestamp: true}
+	logger.AddHook(&maskHook{masker: masker})
+
+	shared := logger.WithField("token", "hunter22")
+	shared.WithError(errors.New("login failed with hunter22")).WithField("attempt", 2).Error("password hunter22")
+
+	logged := buf.String()
+	if strings.Contains(logged, "hunter22") {
+		t.Errorf("expected the secret to be masked\n%s", logged)
+	}
+	for _, expected := range []string{`msg="password ***"`, "token=\"***\"", `error="login failed with ***"`, "attempt=2"} {
+		if !strings.Contains(logged, expected) {
+			t.Errorf("expected %s in\n%s", expected, logged)
+		}
+	}
+
+	// the fields of other entries are left alone
+	if shared.Data["token"] != "hunter22" {
+		t.Errorf("unexpected change to the fields %v", shared.Data)
+	}
+}
+
+func TestMaskWriter(t *testing.T) {
+	secret := "hunter22-very-secret"
+	masker := NewMasker()
+	masker.Add(secret)
+
+	long := strings.Repeat("x", MaxLineLength)
+	tests := []struct {
+		name   string
+		writes []string
+	}{
+		{
+			name:   "split over writes",
+			writes: []string{"password hun", "ter22-very-", "secret\nnext line\n"},
+		},
+		{
+			name:   "long line",
+			writes: []string{long[:MaxLineLength-5], "hunter22-very-secret", long},
+		},
+		{
+			name:   "secret at the end of a long line",
+			writes: []string{long[:MaxLineLength-5] + "hunter2", "2-very-secret and more"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := &closeBuffer{}
+			writer := newMaskWriter(masker, buf)
+			for _, write := range test.writes {
+				if _, err := writer.Write([]byte(write)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			expected := strings.Replace(strings.Join(test.writes, ""), secret, MaskedValue, -1)
+			if buf.String() != expected {
+				t.Errorf("expected %d bytes with the secret masked, got %d bytes (masked %v)", len(expected), buf.Len(), !strings.Contains(buf.String(), secret))
+			}
+		})
+	}
+}
+
+func TestMaskWriterFlushesLongLines(t *testing.T) {
+	masker := NewMasker()
+	masker.Add("hunter22")
+
+	buf := &closeBuffer{}
+	writer := newMaskWriter(masker, buf)
+	if _, err := writer.Write([]byte(strings.Repeat("x", MaxLineLength+10))); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the bytes that could start a secret (or its base64 forms) are held back
+	if buf.Len() != MaxLineLength+10-masker.longest+1 {
+		t.Errorf("unexpected number of bytes written %d", buf.Len())
+	}
+}
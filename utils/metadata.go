@@ -0,0 +1,257 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// MetadataSourceWorkflow is the source of metadata set in the workflow file
+	MetadataSourceWorkflow = "workflow"
+	// MetadataSourceOptions is the source of metadata set in WorkflowOptions.Metadata
+	MetadataSourceOptions = "options"
+)
+
+// Metadata holds the metadata of a workflow or a step. Values keep their YAML
+// types so they can be numbers, booleans, lists or nested maps
+type Metadata map[string]interface{}
+
+// UnmarshalYAML implements yaml.Unmarshaler. Nested maps are turned into
+// map[string]interface{} so they can be written as JSON
+func (m *Metadata) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var values map[string]interface{}
+	if err := unmarshal(&values); err != nil {
+		return err
+	}
+
+	*m = make(Metadata, len(values))
+	for key, value := range values {
+		(*m)[key] = normalizeMetadataValue(value)
+	}
+
+	return nil
+}
+
+func normalizeMetadataValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[interface{}]interface{}:
+		normalized := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			normalized[fmt.Sprint(key)] = normalizeMetadataValue(item)
+		}
+		return normalized
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			normalized[key] = normalizeMetadataValue(item)
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(typed))
+		for idx, item := range typed {
+			normalized[idx] = normalizeMetadataValue(item)
+		}
+		return normalized
+	default:
+		return value
+	}
+}
+
+// String returns the value of key as a string. Lists and maps are returned
+// as JSON. It is "" if there is no value for key
+func (m Metadata) String(key string) string {
+	return metadataString(m[key])
+}
+
+func metadataString(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case map[string]interface{}, []interface{}:
+		buf, err := json.Marshal(typed)
+		if err != nil {
+			return fmt.Sprint(typed)
+		}
+		return string(buf)
+	default:
+		return fmt.Sprint(typed)
+	}
+}
+
+// Flatten returns all values as strings with the keys of nested maps joined
+// with dots, like db.host. Lists are returned as JSON
+func (m Metadata) Flatten() map[string]string {
+	flat := make(map[string]string, len(m))
+	m.walk(func(path string, value interface{}) {
+		flat[path] = metadataString(value)
+	})
+
+	return flat
+}
+
+// walk calls fn for every value that isn't a map, with the keys that lead to it joined by dots
+func (m Metadata) walk(fn func(path string, value interface{})) {
+	walkMetadata("", map[string]interface{}(m), fn)
+}
+
+func walkMetadata(prefix string, values map[string]interface{}, fn func(path string, value interface{})) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		if nested, ok := values[key].(map[string]interface{}); ok {
+			walkMetadata(path, nested, fn)
+			continue
+		}
+		fn(path, values[key])
+	}
+}
+
+// transformStrings returns a copy of value with fn applied to every string
+// in it, including those in lists and nested maps
+func transformStrings(value interface{}, fn func(string) (string, error)) (interface{}, error) {
+	switch typed := value.(type) {
+	case string:
+		return fn(typed)
+	case map[string]interface{}:
+		transformed := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			result, err := transformStrings(item, fn)
+			if err != nil {
+				return nil, err
+			}
+			transformed[key] = result
+		}
+		return transformed, nil
+	case []interface{}:
+		transformed := make([]interface{}, len(typed))
+		for idx, item := range typed {
+			result, err := transformStrings(item, fn)
+			if err != nil {
+				return nil, err
+			}
+			transformed[idx] = result
+		}
+		return transformed, nil
+	default:
+		return value, nil
+	}
+}
+
+// transform applies fn to every string in the metadata
+func (m Metadata) transform(fn func(string) (string, error)) error {
+	for key, value := range m {
+		result, err := transformStrings(value, fn)
+		if err != nil {
+			return err
+		}
+		m[key] = result
+	}
+
+	return nil
+}
+
+// mergeMetadata returns a new Metadata with the values of source merged into
+// target. Nested maps are merged key by key. Anything else in source replaces
+// the value in target
+func mergeMetadata(target, source Metadata) Metadata {
+	merged := make(Metadata, len(target)+len(source))
+	for key, value := range target {
+		merged[key] = value
+	}
+
+	for key, value := range source {
+		targetMap, targetIsMap := merged[key].(map[string]interface{})
+		sourceMap, sourceIsMap := value.(map[string]interface{})
+		if targetIsMap && sourceIsMap {
+			merged[key] = map[string]interface{}(mergeMetadata(targetMap, sourceMap))
+			continue
+		}
+
+		merged[key] = value
+	}
+
+	return merged
+}
+
+// ReadMetadataFile reads metadata from a YAML or JSON file
+func ReadMetadataFile(name string) (Metadata, error) {
+	buff, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata Metadata
+	if err = yaml.Unmarshal(buff, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata file %s: %s", name, err)
+	}
+
+	return metadata, nil
+}
+
+// MetadataFromEnv returns the TRACKMAN_META_ variables in env as metadata.
+// The rest of the name is lower cased to make the key, so
+// TRACKMAN_META_REGION=eu is region: eu
+func MetadataFromEnv(env []string) Metadata {
+	metadata := make(Metadata)
+	for _, entry := range env {
+		keyValue := strings.SplitN(entry, "=", 2)
+		if len(keyValue) != 2 || !strings.HasPrefix(keyValue[0], EnvMetadataPrefix) {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimPrefix(keyValue[0], EnvMetadataPrefix))
+		if key != "" {
+			metadata[key] = keyValue[1]
+		}
+	}
+
+	return metadata
+}
+
+// AddMetadata merges metadata into the MetadataValues of the options with
+// source as where its values came from. Values added later win
+func (o *WorkflowOptions) AddMetadata(metadata Metadata, source string) {
+	o.MetadataValues = mergeMetadata(o.MetadataValues, metadata)
+
+	if o.MetadataSources == nil {
+		o.MetadataSources = make(map[string]string)
+	}
+	metadata.walk(func(path string, value interface{}) {
+		o.MetadataSources[path] = source
+	})
+}
+
+// MetadataSources returns where each value of the workflow metadata came
+// from, by its path like db.host
+func (w *Workflow) MetadataSources() map[string]string {
+	sources := make(map[string]string)
+	w.Metadata.walk(func(path string, value interface{}) {
+		source, ok := w.options.MetadataSources[path]
+		if !ok {
+			if _, ok = w.options.Metadata[path]; ok {
+				source = MetadataSourceOptions
+			} else {
+				source = MetadataSourceWorkflow
+			}
+		}
+		sources[path] = source
+	})
+
+	return sources
+}
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// OutputNone is the level for output that shouldn't be logged
+	OutputNone = "none"
+
+	defaultStdoutLevel = logrus.DebugLevel
+	defaultStderrLevel = logrus.ErrorLevel
+)
+
+// OutputDefinition controls where the output of a process goes
+type OutputDefinition struct {
+	// Stdout is the log level of stdout or none
+	Stdout string `yaml:"stdout" json:"stdout"`
+	// Stderr is the log level of stderr or none
+	Stderr string `yaml:"stderr" json:"stderr"`
+	// StdoutFile is a file stdout is written to as it is
+	StdoutFile string `yaml:"stdout_file" json:"stdout_file"`
+	// StderrFile is a file stderr is written to as it is
+	StderrFile string `yaml:"stderr_file" json:"stderr_file"`
+}
+
+// mergeOutputDefinitions returns the definition with any empty level taken from
+// fallback. Files are not taken from fallback, so the probes and preflights of
+// a step don't write to the files of the step command
+func mergeOutputDefinitions(definition, fallback *OutputDefinition) *OutputDefinition {
+	if fallback == nil {
+		return definition
+	}
+
+	merged := OutputDefinition{}
+	if definition != nil {
+		merged = *definition
+	}
+	if merged.Stdout == "" {
+		merged.Stdout = fallback.Stdout
+	}
+	if merged.Stderr == "" {
+		merged.Stderr = fallback.Stderr
+	}
+
+	return &merged
+}
+
+// validate checks the levels of the definition
+func (o *OutputDefinition) validate() error {
+	if o == nil {
+		return nil
+	}
+
+	if _, _, err := parseOutputLevel(o.Stdout, defaultStdoutLevel); err != nil {
+		return err
+	}
+	if _, _, err := parseOutputLevel(o.Stderr, defaultStderrLevel); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// stdoutLevel returns the log level of stdout and false if it shouldn't be logged
+func (o *OutputDefinition) stdoutLevel() (logrus.Level, bool) {
+	if o == nil {
+		return defaultStdoutLevel, true
+	}
+
+	level, enabled, _ := parseOutputLevel(o.Stdout, defaultStdoutLevel)
+	return level, enabled
+}
+
+// stderrLevel returns the log level of stderr and false if it shouldn't be logged
+func (o *OutputDefinition) stderrLevel() (logrus.Level, bool) {
+	if o == nil {
+		return defaultStderrLevel, true
+	}
+
+	level, enabled, _ := parseOutputLevel(o.Stderr, defaultStderrLevel)
+	return level, enabled
+}
+
+func parseOutputLevel(value string, defaultLevel logrus.Level) (logrus.Level, bool, error) {
+	if value == "" {
+		return defaultLevel, true, nil
+	}
+	if value == OutputNone {
+		return defaultLevel, false, nil
+	}
+
+	level, err := logrus.ParseLevel(value)
+	if err != nil {
+		return defaultLevel, false, fmt.Errorf("invalid output level %s. Valid values are %s and the log levels", value, OutputNone)
+	}
+
+	return level, true, nil
+}
+
+// outputFile opens the file raw output is written to with secrets masked.
+// It returns nil if name is empty
+func outputFile(name string, masker *Masker) (io.WriteCloser, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMaskWriter(masker, file), nil
+}
+
+// outputWriter adds file to the writers of the output if it's not nil
+func outputWriter(writer io.Writer, file io.Writer) io.Writer {
+	if file == nil {
+		return writer
+	}
+
+	return io.MultiWriter(writer, file)
+}
@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	// OutputTailSize is the number of output lines included in events
+	OutputTailSize = 20
+	// outputBufferSize is the number of output lines kept for each stream of a spinner
+	outputBufferSize = 500
+	// outputBufferBytes is the most output kept in memory by a buffer
+	outputBufferBytes = 1 << 20
+)
+
+// outputFiles holds the files output buffers have spilled to so they can be
+// removed when Trackman exits. Files set to true are kept
+var outputFiles = struct {
+	files map[string]bool
+	lock  sync.Mutex
+}{
+	files: make(map[string]bool),
+}
+
+// OutputBuffer keeps the most recent lines of a process output. If it's
+// created with a spill file pattern, all of the output is written to a
+// temporary file once the buffer is full
+type OutputBuffer struct {
+	lines    []string
+	start    int
+	size     int
+	bytes    int
+	maxBytes int
+
+	spillPattern string
+	file         *os.File
+	writer       *bufio.Writer
+	spillErr     error
+
+	lock sync.Mutex
+}
+
+// NewOutputBuffer creates a new OutputBuffer holding up to capacity lines
+func NewOutputBuffer(capacity int) *OutputBuffer {
+	return &OutputBuffer{
+		lines:    make([]string, capacity),
+		maxBytes: outputBufferBytes,
+	}
+}
+
+// NewSpillingOutputBuffer creates a new OutputBuffer holding up to capacity
+// lines that writes all of the output to a temporary file once it has to
+// drop lines. pattern names the file like ioutil.TempFile
+func NewSpillingOutputBuffer(capacity int, pattern string) *OutputBuffer {
+	buffer := NewOutputBuffer(capacity)
+	buffer.spillPattern = pattern
+
+	return buffer
+}
+
+// Add adds a line to the buffer, dropping the oldest line if the buffer is full
+func (o *OutputBuffer) Add(line string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if len(o.lines) == 0 {
+		return
+	}
+
+	for o.size > 0 && (o.size == len(o.lines) || o.bytes+len(line) > o.maxBytes) {
+		if o.spillPattern != "" && o.file == nil && o.spillErr == nil {
+			o.spill()
+		}
+
+		o.bytes -= len(o.lines[o.start])
+		o.lines[o.start] = ""
+		o.start = (o.start + 1) % len(o.lines)
+		o.size--
+	}
+
+	o.lines[(o.start+o.size)%len(o.lines)] = line
+	o.size++
+	o.bytes += len(line)
+
+	if o.writer != nil {
+		o.writeLine(line)
+	}
+}
+
+// Tail returns the last n lines in the buffer
+func (o *OutputBuffer) Tail(n int) []string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if n > o.size {
+		n = o.size
+	}
+
+	result := make([]string, 0, n)
+	for idx := o.size - n; idx < o.size; idx++ {
+		result = append(result, o.lines[(o.start+idx)%len(o.lines)])
+	}
+
+	return result
+}
+
+// Lines returns all the lines in the buffer
+func (o *OutputBuffer) Lines() []string {
+	return o.Tail(len(o.lines))
+}
+
+// File returns the name of the file holding all of the output. It is
+// empty if the output fits in the buffer
+func (o *OutputBuffer) File() string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.file == nil {
+		return ""
+	}
+
+	return o.file.Name()
+}
+
+// Flush writes the lines buffered for the file holding the output, so the
+// file can be read while the process runs
+func (o *OutputBuffer) Flush() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.writer == nil {
+		return o.spillErr
+	}
+
+	if err := o.writer.Flush(); err != nil {
+		o.spillErr = err
+		o.writer = nil
+	}
+
+	return o.spillErr
+}
+
+// Keep makes sure the file holding the output is not removed when Trackman exits
+func (o *OutputBuffer) Keep() {
+	name := o.File()
+	if name == "" {
+		return
+	}
+
+	outputFiles.lock.Lock()
+	defer outputFiles.lock.Unlock()
+
+	outputFiles.files[name] = true
+}
+
+// Remove removes the file holding the output, if any. It should be called
+// after Close. The lines in memory are kept
+func (o *OutputBuffer) Remove() error {
+	o.lock.Lock()
+	file := o.file
+	o.file = nil
+	o.lock.Unlock()
+
+	if file == nil {
+		return nil
+	}
+
+	return RemoveOutputFile(file.Name())
+}
+
+// Close flushes the file holding the output
+func (o *OutputBuffer) Close() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.file == nil {
+		return o.spillErr
+	}
+
+	if o.spillErr == nil {
+		o.spillErr = o.writer.Flush()
+	}
+	o.writer = nil
+
+	if err := o.file.Close(); err != nil && o.spillErr == nil {
+		o.spillErr = err
+	}
+
+	return o.spillErr
+}
+
+// spill writes the lines in the buffer to a new temporary file. It should
+// be called with the lock held and before any lines are dropped
+func (o *OutputBuffer) spill() {
+	file, err := ioutil.TempFile("", o.spillPattern)
+	if err != nil {
+		o.spillErr = err
+		return
+	}
+
+	outputFiles.lock.Lock()
+	outputFiles.files[file.Name()] = false
+	outputFiles.lock.Unlock()
+
+	o.file = file
+	o.writer = bufio.NewWriter(file)
+	for idx := 0; idx < o.size; idx++ {
+		o.writeLine(o.lines[(o.start+idx)%len(o.lines)])
+	}
+}
+
+func (o *OutputBuffer) writeLine(line string) {
+	if _, err := o.writer.WriteString(line + "\n"); err != nil {
+		// keep the lines in memory at least
+		o.spillErr = err
+		o.writer = nil
+	}
+}
+
+// RemoveOutputFile removes a temporary output file, even if it's kept
+func RemoveOutputFile(name string) error {
+	outputFiles.lock.Lock()
+	defer outputFiles.lock.Unlock()
+
+	if _, ok := outputFiles.files[name]; !ok {
+		return nil
+	}
+	delete(outputFiles.files, name)
+
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// RemoveOutputFiles removes the temporary output files that are not kept
+func RemoveOutputFiles() error {
+	outputFiles.lock.Lock()
+	defer outputFiles.lock.Unlock()
+
+	var errors error
+	for name, keep := range outputFiles.files {
+		if keep {
+			continue
+		}
+
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			errors = multierror.Append(errors, err)
+		}
+		delete(outputFiles.files, name)
+	}
+
+	return errors
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func spilledBuffer(t *testing.T) *OutputBuffer {
+	t.Helper()
+
+	buffer := NewSpillingOutputBuffer(2, "trackman-test-*.log")
+	for idx := 0; idx < 3; idx++ {
+		buffer.Add(fmt.Sprintf("line %d", idx))
+	}
+	if err := buffer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buffer.File() == "" {
+		t.Fatal("expected the output to spill to a file")
+	}
+
+	return buffer
+}
+
+func TestOutputBufferRemove(t *testing.T) {
+	buffer := spilledBuffer(t)
+	name := buffer.File()
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "line 0\nline 1\nline 2\n" {
+		t.Errorf("unexpected output file %q", data)
+	}
+
+	if err = buffer.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", name)
+	}
+	if buffer.File() != "" {
+		t.Errorf("expected no output file after Remove, got %s", buffer.File())
+	}
+	if lines := buffer.Lines(); len(lines) != 2 || lines[1] != "line 2" {
+		t.Errorf("expected the lines in memory to be kept, got %v", lines)
+	}
+}
+
+func TestRemoveOutputFiles(t *testing.T) {
+	kept := spilledBuffer(t)
+	kept.Keep()
+	removed := spilledBuffer(t)
+
+	if err := RemoveOutputFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(removed.File()); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", removed.File())
+	}
+	if _, err := os.Stat(kept.File()); err != nil {
+		t.Errorf("expected %s to be kept: %s", kept.File(), err)
+	}
+
+	// kept files can still be removed once they aren't needed
+	if err := RemoveOutputFile(kept.File()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(kept.File()); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", kept.File())
+	}
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeOutputDefinitions(t *testing.T) {
+	step := &OutputDefinition{Stdout: "info", Stderr: "warn", StdoutFile: "step.out", StderrFile: "step.err"}
+
+	tests := []struct {
+		name       string
+		definition *OutputDefinition
+		fallback   *OutputDefinition
+		expected   *OutputDefinition
+	}{
+		{
+			name:     "levels only",
+			fallback: step,
+			expected: &OutputDefinition{Stdout: "info", Stderr: "warn"},
+		},
+		{
+			name:       "own files",
+			definition: &OutputDefinition{Stderr: "error", StdoutFile: "probe.out"},
+			fallback:   step,
+			expected:   &OutputDefinition{Stdout: "info", Stderr: "error", StdoutFile: "probe.out"},
+		},
+		{
+			name:       "no fallback",
+			definition: &OutputDefinition{Stdout: "none", StderrFile: "probe.err"},
+			expected:   &OutputDefinition{Stdout: "none", StderrFile: "probe.err"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if merged := mergeOutputDefinitions(test.definition, test.fallback); !reflect.DeepEqual(merged, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, merged)
+			}
+		})
+	}
+
+	if mergeOutputDefinitions(nil, nil) != nil {
+		t.Errorf("expected no definition")
+	}
+}
+
+func TestOutputFilesOfProbesAndPreflights(t *testing.T) {
+	dir := t.TempDir()
+	workflow, err := loadFiles(t, dir, map[string]string{
+		"workflow.yml": fmt.Sprintf(`
+version: 1
+steps:
+  - name: build
+    command: echo step
+    output:
+      stdout_file: %[1]s/step.out
+    probe:
+      command: echo probe
+    preflights:
+      - command: echo preflight
+      - command: echo checked
+        output:
+          stdout_file: %[1]s/preflight.out
+`, dir),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err, stepErrors := workflow.Run(context.Background()); err != nil || stepErrors != nil {
+		t.Fatal(err, stepErrors)
+	}
+
+	for name, expected := range map[string]string{
+		"step.out":      "step\n",
+		"preflight.out": "checked\n",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("expected %q in %s, got %q", expected, name, data)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// ParamString is a param with a string value. It is the default type
+	ParamString = "string"
+	// ParamInt is a param with an integer value
+	ParamInt = "int"
+	// ParamBool is a param with a true or false value
+	ParamBool = "bool"
+	// ParamEnum is a param with one of the values listed in its definition
+	ParamEnum = "enum"
+	// ParamList is a param with a list of strings. As a string, items are separated by commas
+	ParamList = "list"
+)
+
+// ParamDefinition is a typed parameter of a workflow
+type ParamDefinition struct {
+	Name        string      `yaml:"name" json:"name"`
+	Type        string      `yaml:"type" json:"type"`
+	Default     interface{} `yaml:"default,omitempty" json:"default,omitempty"`
+	Required    bool        `yaml:"required" json:"required"`
+	Description string      `yaml:"description,omitempty" json:"description,omitempty"`
+	// Values are the valid values of an enum
+	Values []string `yaml:"values,omitempty" json:"values,omitempty"`
+}
+
+func (p *ParamDefinition) validate() error {
+	if p.Name == "" {
+		return errors.New("params should have a name")
+	}
+	if p.Type == "" {
+		p.Type = ParamString
+	}
+
+	switch p.Type {
+	case ParamString, ParamInt, ParamBool, ParamList:
+	case ParamEnum:
+		if len(p.Values) == 0 {
+			return fmt.Errorf("param %s is an enum without any values", p.Name)
+		}
+	default:
+		return fmt.Errorf("param %s has an invalid type %s. Valid types are %s, %s, %s, %s and %s", p.Name, p.Type, ParamString, ParamInt, ParamBool, ParamEnum, ParamList)
+	}
+
+	if p.Default != nil {
+		if _, err := p.Convert(p.Default); err != nil {
+			return fmt.Errorf("invalid default: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// Convert checks value against the type of the param and returns it as that
+// type. Strings are parsed, so "3" is a valid int
+func (p *ParamDefinition) Convert(value interface{}) (interface{}, error) {
+	text, isText := value.(string)
+
+	switch p.Type {
+	case ParamInt:
+		switch typed := value.(type) {
+		case int:
+			return typed, nil
+		case float64:
+			// numbers in JSON
+			if typed == math.Trunc(typed) {
+				return int(typed), nil
+			}
+		case string:
+			number, err := strconv.Atoi(strings.TrimSpace(typed))
+			if err != nil {
+				return nil, fmt.Errorf("param %s should be an integer, not %s", p.Name, typed)
+			}
+			return number, nil
+		}
+	case ParamBool:
+		switch typed := value.(type) {
+		case bool:
+			return typed, nil
+		case string:
+			flag, err := strconv.ParseBool(strings.TrimSpace(typed))
+			if err != nil {
+				return nil, fmt.Errorf("param %s should be true or false, not %s", p.Name, typed)
+			}
+			return flag, nil
+		}
+	case ParamEnum:
+		if !isText {
+			text = fmt.Sprint(value)
+		}
+		for _, valid := range p.Values {
+			if text == valid {
+				return text, nil
+			}
+		}
+		return nil, fmt.Errorf("param %s should be one of %s, not %s", p.Name, strings.Join(p.Values, ", "), text)
+	case ParamList:
+		switch typed := value.(type) {
+		case []interface{}:
+			list := make([]string, 0, len(typed))
+			for _, item := range typed {
+				list = append(list, fmt.Sprint(item))
+			}
+			return list, nil
+		case []string:
+			return typed, nil
+		case string:
+			list := []string{}
+			for _, item := range strings.Split(typed, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			return list, nil
+		}
+	default:
+		switch value.(type) {
+		case []interface{}, map[interface{}]interface{}, map[string]interface{}:
+		default:
+			return fmt.Sprint(value), nil
+		}
+	}
+
+	return nil, fmt.Errorf("param %s should be a %s, not %v", p.Name, p.Type, value)
+}
+
+// zero returns the value of an optional param that isn't given
+func (p *ParamDefinition) zero() interface{} {
+	switch p.Type {
+	case ParamInt:
+		return 0
+	case ParamBool:
+		return false
+	case ParamList:
+		return []string{}
+	default:
+		return ""
+	}
+}
+
+// ReadParamsFile reads the values of params from a YAML or JSON file
+func ReadParamsFile(name string) (map[string]interface{}, error) {
+	buff, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]interface{}
+	if err = yaml.Unmarshal(buff, &values); err != nil {
+		return nil, fmt.Errorf("invalid params file %s: %s", name, err)
+	}
+
+	return values, nil
+}
+
+// loadParams checks the given params against their definitions and fills in
+// defaults. Required params without a value are asked for with the Prompt
+// option if it's set
+func (w *Workflow) loadParams() error {
+	w.params = make(map[string]interface{}, len(w.ParamDefinitions))
+
+	defined := make(map[string]bool, len(w.ParamDefinitions))
+	for _, param := range w.ParamDefinitions {
+		if err := param.validate(); err != nil {
+			return err
+		}
+		if defined[param.Name] {
+			return fmt.Errorf("param %s is defined more than once", param.Name)
+		}
+		defined[param.Name] = true
+	}
+
+	var unknown []string
+	for name := range w.options.Params {
+		if !defined[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown params %s", strings.Join(unknown, ", "))
+	}
+
+	for _, param := range w.ParamDefinitions {
+		value, given := w.options.Params[param.Name]
+		if !given && param.Default != nil {
+			value, given = param.Default, true
+		}
+		if !given && param.Required && w.options.Prompt != nil {
+			answer, err := w.options.Prompt(param)
+			if err != nil {
+				return err
+			}
+			value, given = answer, true
+		}
+		if !given {
+			if param.Required {
+				return fmt.Errorf("param %s is required", param.Name)
+			}
+			w.params[param.Name] = param.zero()
+			continue
+		}
+
+		converted, err := param.Convert(value)
+		if err != nil {
+			return err
+		}
+		w.params[param.Name] = converted
+	}
+
+	return nil
+}
+
+// Params returns the values of the params of the workflow. They can be used
+// in templates like {{ .Params.region }}
+func (w *Workflow) Params() map[string]interface{} {
+	return w.params
+}
+
+// Params returns the values of the params of the workflow
+func (s *Step) Params() map[string]interface{} {
+	return s.workflow.params
+}
@@ -1,9 +1,46 @@
 package utils
 
-// Payload is what's sent over to a notifier
+import "time"
+
+// Payload is what's sent over to a notifier. Spinner and Step
+// are nil for workflow level events and Spinner is nil for step
+// level events. Run specific fields (exit code, output, etc) are
+// only set on events coming from a Spinner. Notifiers that send events
+// out of the process should use notifiers.NewEventRecord instead of
+// serializing the payload
 type Payload struct {
 	EventUUID string
+	SessionID string
+	Timestamp time.Time
+	Workflow  *Workflow
 	Spinner   *Spinner
-	Step      Step
-	Extras    interface{}
+	Step      *Step
+	// StepName is the name of the step when the event was created
+	StepName   string
+	StartedAt  *time.Time
+	EndedAt    *time.Time
+	Duration   time.Duration
+	QueueWait  time.Duration
+	ExitCode   *int
+	Signal     string
+	TimedOut   bool
+	Attempt    int
+	Stdout     []string
+	Stderr     []string
+	Output     []string
+	OutputFile string
+	Error      string
+	Confirmed  *bool
+}
+
+// setTiming fills the timing fields of the payload. Zero times are ignored
+func (p *Payload) setTiming(startedAt, endedAt time.Time) {
+	p.StartedAt, p.EndedAt, p.Duration = timing(startedAt, endedAt)
+}
+
+// setError fills the error field of the payload if err is not nil
+func (p *Payload) setError(err error) {
+	if err != nil {
+		p.Error = err.Error()
+	}
 }
@@ -8,10 +8,11 @@ import (
 
 // Preflight is a check that runs at the beginning of the workflow
 type Preflight struct {
-	Command string         `yaml:"command" json:"command"`
-	Message string         `yaml:"message" json:"message"`
-	Workdir string         `yaml:"workdir" json:"workdir"`
-	Timeout *time.Duration `yaml:"timeout" json:"timeout"`
+	Command string            `yaml:"command" json:"command"`
+	Message string            `yaml:"message" json:"message"`
+	Workdir string            `yaml:"workdir" json:"workdir"`
+	Timeout *time.Duration    `yaml:"timeout" json:"timeout"`
+	Output  *OutputDefinition `yaml:"output" json:"output"`
 
 	step *Step
 }
@@ -25,5 +26,8 @@ func (p *Preflight) Run(ctx context.Context) error {
 		return err
 	}
 
-	return spinner.Run(ctx)
+	err = spinner.Run(ctx)
+	p.step.record.addPreflight(spinner.Report())
+
+	return err
 }
@@ -2,8 +2,9 @@ package utils
 
 // Probe defines a checker for a Step's health
 type Probe struct {
-	Command string `yaml:"command" json:"command"`
-	Workdir string `yaml:"workdir" json:"workdir"`
+	Command string            `yaml:"command" json:"command"`
+	Workdir string            `yaml:"workdir" json:"workdir"`
+	Output  *OutputDefinition `yaml:"output" json:"output"`
 
 	cmd  string
 	args []string
@@ -0,0 +1,306 @@
+package utils
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	// StatusPending is used for steps and workflows that haven't started
+	StatusPending = "pending"
+	// StatusRunning is used for steps and workflows that are running
+	StatusRunning = "running"
+	// StatusSuccess is used for successful runs
+	StatusSuccess = "success"
+	// StatusFailed is used for failed runs
+	StatusFailed = "failed"
+	// StatusSkipped is used for steps that never ran because the workflow stopped
+	StatusSkipped = "skipped"
+	// StatusDisabled is used for disabled steps
+	StatusDisabled = "disabled"
+	// StatusCancelled is used for steps that were cancelled before running
+	StatusCancelled = "cancelled"
+)
+
+// RunReport is the result of a single process run (step, probe or preflight)
+type RunReport struct {
+	Name       string        `json:"name"`
+	Kind       string        `json:"kind"`
+	Status     string        `json:"status"`
+	StartedAt  *time.Time    `json:"started_at,omitempty"`
+	EndedAt    *time.Time    `json:"ended_at,omitempty"`
+	Duration   time.Duration `json:"duration"`
+	ExitCode   *int          `json:"exit_code,omitempty"`
+	Signal     string        `json:"signal,omitempty"`
+	TimedOut   bool          `json:"timed_out,omitempty"`
+	Attempt    int           `json:"attempt"`
+	Error      string        `json:"error,omitempty"`
+	Stdout     []string      `json:"stdout,omitempty"`
+	Stderr     []string      `json:"stderr,omitempty"`
+	Output     []string      `json:"output,omitempty"`
+	OutputFile string        `json:"output_file,omitempty"`
+}
+
+// StepReport is the result of a step
+type StepReport struct {
+	Name       string        `json:"name"`
+	Status     string        `json:"status"`
+	DependsOn  []string      `json:"depends_on,omitempty"`
+	StartedAt  *time.Time    `json:"started_at,omitempty"`
+	EndedAt    *time.Time    `json:"ended_at,omitempty"`
+	Duration   time.Duration `json:"duration"`
+	Attempts   int           `json:"attempts"`
+	ExitCode   *int          `json:"exit_code,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Runs       []*RunReport  `json:"runs,omitempty"`
+	Preflights []*RunReport  `json:"preflights,omitempty"`
+}
+
+// WorkflowReport is the result of a workflow run
+type WorkflowReport struct {
+	Name      string        `json:"name"`
+	SessionID string        `json:"session_id"`
+	Status    string        `json:"status"`
+	StartedAt *time.Time    `json:"started_at,omitempty"`
+	EndedAt   *time.Time    `json:"ended_at,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	Error     string        `json:"error,omitempty"`
+	Steps     []*StepReport `json:"steps"`
+}
+
+// stepRecord collects the runs of a step. It is shared between the step
+// and the copies of it held by its spinners
+type stepRecord struct {
+	runs       []*RunReport
+	preflights []*RunReport
+	err        error
+	// stdout is the output of the step command, used by templates of other steps
+	stdout *OutputBuffer
+	lock   sync.Mutex
+}
+
+func (r *stepRecord) addRun(run *RunReport) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.runs = append(r.runs, run)
+}
+
+func (r *stepRecord) addPreflight(run *RunReport) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.preflights = append(r.preflights, run)
+}
+
+func (r *stepRecord) setStdout(stdout *OutputBuffer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.stdout = stdout
+}
+
+func (r *stepRecord) stdoutLines() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.stdout == nil {
+		return nil
+	}
+
+	return r.stdout.Lines()
+}
+
+func (r *stepRecord) setError(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.err = err
+}
+
+// FailedStep returns the first failed step in the report or nil if no step has failed
+func (w *WorkflowReport) FailedStep() *StepReport {
+	for _, step := range w.Steps {
+		if step.Status == StatusFailed {
+			return step
+		}
+	}
+
+	return nil
+}
+
+// FailedRun returns the last failed run of the step or nil if none has failed
+func (s *StepReport) FailedRun() *RunReport {
+	for idx := len(s.Runs) - 1; idx >= 0; idx-- {
+		if s.Runs[idx].Status == StatusFailed {
+			return s.Runs[idx]
+		}
+	}
+	for idx := len(s.Preflights) - 1; idx >= 0; idx-- {
+		if s.Preflights[idx].Status == StatusFailed {
+			return s.Preflights[idx]
+		}
+	}
+
+	return nil
+}
+
+// Report returns the result of the last run of the spinner
+func (s *Spinner) Report() *RunReport {
+	report := &RunReport{
+		Name:     s.Name,
+		Kind:     s.Kind,
+		Status:   StatusSuccess,
+		Signal:   s.signal,
+		TimedOut: s.timedOut,
+		Attempt:  s.attempt,
+	}
+
+	if s.startedAt.IsZero() {
+		report.Status = StatusPending
+	} else if s.endedAt.IsZero() {
+		report.Status = StatusRunning
+	}
+
+	report.StartedAt, report.EndedAt, report.Duration = timing(s.startedAt, s.endedAt)
+	if s.exitCode != nil {
+		exitCode := *s.exitCode
+		report.ExitCode = &exitCode
+	}
+	if s.err != nil {
+		report.Status = StatusFailed
+		report.Error = s.step.workflow.Masker().Mask(s.err.Error())
+	}
+	if s.stdout != nil {
+		report.Stdout = s.stdout.Tail(OutputTailSize)
+	}
+	if s.stderr != nil {
+		report.Stderr = s.stderr.Tail(OutputTailSize)
+	}
+	if s.output != nil {
+		report.Output = s.output.Tail(OutputTailSize)
+		report.OutputFile = s.output.File()
+	}
+
+	return report
+}
+
+// Report returns the current state of the step and its runs
+func (s *Step) Report() *StepReport {
+	report := &StepReport{
+		DependsOn: s.DependsOn,
+	}
+
+	s.workflow.state.Lock()
+	report.Name = s.Name
+	status, startedAt, endedAt := s.status, s.startedAt, s.endedAt
+	workflowEnded := !s.workflow.endedAt.IsZero()
+	s.workflow.state.Unlock()
+
+	report.StartedAt, report.EndedAt, report.Duration = timing(startedAt, endedAt)
+
+	s.record.lock.Lock()
+	report.Runs = append(report.Runs, s.record.runs...)
+	report.Preflights = append(report.Preflights, s.record.preflights...)
+	err := s.record.err
+	s.record.lock.Unlock()
+
+	for _, run := range report.Runs {
+		if run.Kind == SpinnerKindStep {
+			report.Attempts++
+			report.ExitCode = run.ExitCode
+		}
+	}
+
+	switch {
+	case s.Disabled:
+		report.Status = StatusDisabled
+	case status == stepCancelled:
+		report.Status = StatusCancelled
+	case status == stepRunning:
+		report.Status = StatusRunning
+	case status == stepDone:
+		report.Status = StatusSuccess
+	case !workflowEnded:
+		report.Status = StatusPending
+	default:
+		report.Status = StatusSkipped
+	}
+
+	if err != nil {
+		report.Status = StatusFailed
+		report.Error = s.workflow.Masker().Mask(err.Error())
+	} else if failed := report.FailedRun(); failed != nil && report.Status != StatusRunning {
+		// steps with continue_on_fail don't return the error
+		report.Status = StatusFailed
+		report.Error = failed.Error
+	}
+
+	return report
+}
+
+// Report returns the current state of the workflow and all of its steps
+func (w *Workflow) Report() *WorkflowReport {
+	report := &WorkflowReport{
+		Name:      w.Name,
+		SessionID: w.sessionID,
+	}
+
+	w.state.Lock()
+	startedAt, endedAt, err := w.startedAt, w.endedAt, w.err
+	w.state.Unlock()
+
+	report.StartedAt, report.EndedAt, report.Duration = timing(startedAt, endedAt)
+	switch {
+	case startedAt.IsZero():
+		report.Status = StatusPending
+	case endedAt.IsZero():
+		report.Status = StatusRunning
+	case err != nil:
+		report.Status = StatusFailed
+		report.Error = w.masker.Mask(strings.TrimSpace(err.Error()))
+	default:
+		report.Status = StatusSuccess
+	}
+
+	for _, step := range w.Steps {
+		report.Steps = append(report.Steps, step.Report())
+	}
+
+	return report
+}
+
+// RemoveOutputFiles removes the output files of all runs of the workflow,
+// including the ones kept for failed runs. It's used once the report isn't needed
+func (w *Workflow) RemoveOutputFiles() error {
+	var errors error
+	for _, step := range w.Report().Steps {
+		for _, runs := range [][]*RunReport{step.Runs, step.Preflights} {
+			for _, run := range runs {
+				if run.OutputFile == "" {
+					continue
+				}
+
+				if err := RemoveOutputFile(run.OutputFile); err != nil {
+					errors = multierror.Append(errors, err)
+				}
+			}
+		}
+	}
+
+	return errors
+}
+
+func timing(startedAt, endedAt time.Time) (*time.Time, *time.Time, time.Duration) {
+	if startedAt.IsZero() {
+		return nil, nil, 0
+	}
+	if endedAt.IsZero() {
+		return &startedAt, nil, time.Since(startedAt)
+	}
+
+	return &startedAt, &endedAt, endedAt.Sub(startedAt)
+}
@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+)
+
+// secretCommandTimeout is how long a secret command can take
+const secretCommandTimeout = 30 * time.Second
+
+// SecretDefinition is an entry of the secrets of a workflow. An entry with only
+// a name marks an environment variable or metadata key as a secret. An entry
+// with a source (env, file, command or encrypted) loads the value of the secret
+// which is passed on to the steps as an environment variable called Name
+type SecretDefinition struct {
+	Name string `yaml:"name" json:"name"`
+	// Env is the environment variable of Trackman holding the secret
+	Env string `yaml:"env,omitempty" json:"env,omitempty"`
+	// File is a file holding the secret
+	File string `yaml:"file,omitempty" json:"file,omitempty"`
+	// Command is a command that prints the secret
+	Command string `yaml:"command,omitempty" json:"command,omitempty"`
+	// Encrypted is a file holding the secret encrypted with trackman secret encrypt
+	Encrypted string `yaml:"encrypted,omitempty" json:"encrypted,omitempty"`
+
+	value  string
+	loaded bool
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler so an entry can be a name or a definition
+func (s *SecretDefinition) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		s.Name = name
+		return nil
+	}
+
+	type plain SecretDefinition
+	return unmarshal((*plain)(s))
+}
+
+// MarshalYAML implements yaml.Marshaler. Entries without a source are written as names
+func (s *SecretDefinition) MarshalYAML() (interface{}, error) {
+	if !s.hasSource() {
+		return s.Name, nil
+	}
+
+	type plain SecretDefinition
+	return (*plain)(s), nil
+}
+
+func (s *SecretDefinition) hasSource() bool {
+	return s.Env != "" || s.File != "" || s.Command != "" || s.Encrypted != ""
+}
+
+func (s *SecretDefinition) validate() error {
+	if s.Name == "" {
+		return errors.New("secrets should have a name")
+	}
+
+	sources := 0
+	for _, source := range []string{s.Env, s.File, s.Command, s.Encrypted} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("secret %s should have only one of env, file, command or encrypted", s.Name)
+	}
+
+	return nil
+}
+
+// load gets the value of the secret from its source
+func (s *SecretDefinition) load(ctx context.Context, options *WorkflowOptions) error {
+	var value []byte
+	var err error
+
+	switch {
+	case s.Env != "":
+		env, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return fmt.Errorf("environment variable %s is not set", s.Env)
+		}
+		value = []byte(env)
+	case s.File != "":
+		value, err = ioutil.ReadFile(options.path(s.File))
+	case s.Command != "":
+		value, err = secretCommandOutput(ctx, s.Command, options.BaseDir)
+	case s.Encrypted != "":
+		value, err = s.decrypt(options)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// files and commands usually end with a new line that isn't part of the secret
+	s.value = strings.TrimRight(string(value), "\r\n")
+	s.loaded = true
+
+	return nil
+}
+
+func (s *SecretDefinition) decrypt(options *WorkflowOptions) ([]byte, error) {
+	key, err := LoadSecretKey(options.SecretKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(options.path(s.Encrypted))
+	if err != nil {
+		return nil, err
+	}
+
+	return DecryptSecret(key, data)
+}
+
+func secretCommandOutput(ctx context.Context, command string, workdir string) ([]byte, error) {
+	parts, err := shellquote.Split(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("empty command")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, secretCommandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
+	cmd.Dir = workdir
+
+	output, err := cmd.Output()
+	if err != nil {
+		// stderr is left out of the error as it could hold part of the secret
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("timed out after %s", secretCommandTimeout)
+		}
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// loadSecrets loads the values of all secrets with a source and adds them to the masker
+func (w *Workflow) loadSecrets(ctx context.Context) error {
+	for _, secret := range w.Secrets {
+		if !secret.hasSource() || secret.loaded {
+			continue
+		}
+
+		w.logger.Debugf("Loading secret %s", secret.Name)
+		if err := secret.load(ctx, w.options); err != nil {
+			return fmt.Errorf("failed to load secret %s: %s", secret.Name, err)
+		}
+
+		// secrets are masked whatever their length
+		w.masker.Add(secret.value)
+	}
+
+	return nil
+}
+
+// secretEnv returns the loaded secrets as NAME=value environment variables
+func (w *Workflow) secretEnv() []string {
+	var env []string
+	for _, secret := range w.Secrets {
+		if secret.loaded {
+			env = append(env, secret.Name+"="+secret.value)
+		}
+	}
+
+	return env
+}
+
+// secretNames returns the names of all secrets of the workflow
+func (w *Workflow) secretNames() []string {
+	names := make([]string, 0, len(w.Secrets))
+	for _, secret := range w.Secrets {
+		names = append(names, secret.Name)
+	}
+
+	return names
+}
+
+// path returns name relative to the base directory of the workflow
+func (o *WorkflowOptions) path(name string) string {
+	if filepath.IsAbs(name) || o.BaseDir == "" {
+		return name
+	}
+
+	return filepath.Join(o.BaseDir, name)
+}
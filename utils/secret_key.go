@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const (
+	// SecretKeyEnv holds the private key, base64 encoded. It is used instead of the key file when set
+	SecretKeyEnv = "TRACKMAN_SECRET_KEY"
+	// PublicKeySuffix is added to the name of the private key file to get the public key file
+	PublicKeySuffix = ".pub"
+
+	// secretKeySize is the size of the curve25519 keys used for encrypted secrets
+	secretKeySize = 32
+	// encryptedSecretPrefix starts every encrypted secret so the format can change later
+	encryptedSecretPrefix = "trackman.secret/v1:"
+)
+
+// DefaultSecretKeyFile returns the default location of the private key
+func DefaultSecretKeyFile() (string, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".trackman", "secret.key"), nil
+}
+
+// GenerateSecretKey creates a new key pair. The private key is written to path
+// and the public key to path with PublicKeySuffix. Existing keys are not overwritten
+func GenerateSecretKey(path string) error {
+	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if _, err = os.Stat(path + PublicKeySuffix); err == nil {
+		return fmt.Errorf("%s already exists", path+PublicKeySuffix)
+	}
+
+	if err = writeKey(path, privateKey[:], 0600); err != nil {
+		return err
+	}
+
+	return writeKey(path+PublicKeySuffix, publicKey[:], 0644)
+}
+
+func writeKey(path string, key []byte, mode os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists", path)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err = fmt.Fprintln(file, base64.StdEncoding.EncodeToString(key)); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// LoadSecretKey returns the private key in SecretKeyEnv or in path if that is
+// not set. An empty path means the default key file
+func LoadSecretKey(path string) ([]byte, error) {
+	encoded := os.Getenv(SecretKeyEnv)
+	if encoded == "" {
+		if path == "" {
+			var err error
+			if path, err = DefaultSecretKeyFile(); err != nil {
+				return nil, err
+			}
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the secret key: %s", err)
+		}
+		encoded = string(data)
+	}
+
+	return decodeKey(encoded, "invalid secret key. Create one with trackman secret keygen")
+}
+
+// LoadPublicKey returns the public key in path. An empty path means the public
+// key next to the default key file
+func LoadPublicKey(path string) ([]byte, error) {
+	if path == "" {
+		keyFile, err := DefaultSecretKeyFile()
+		if err != nil {
+			return nil, err
+		}
+		path = keyFile + PublicKeySuffix
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the public key: %s", err)
+	}
+
+	return decodeKey(string(data), fmt.Sprintf("invalid public key in %s", path))
+}
+
+func decodeKey(encoded string, message string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != secretKeySize {
+		return nil, errors.New(message)
+	}
+
+	return key, nil
+}
+
+// EncryptSecret encrypts plaintext for the owner of publicKey with a NaCl
+// anonymous sealed box. Only the private key can decrypt it
+func EncryptSecret(publicKey []byte, plaintext []byte) ([]byte, error) {
+	if len(publicKey) != secretKeySize {
+		return nil, errors.New("invalid public key")
+	}
+
+	var recipient [secretKeySize]byte
+	copy(recipient[:], publicKey)
+
+	sealed, err := box.SealAnonymous(nil, plaintext, &recipient, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(encryptedSecretPrefix + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+// DecryptSecret decrypts data encrypted with EncryptSecret using the private key
+func DecryptSecret(privateKey []byte, data []byte) ([]byte, error) {
+	text := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(text, encryptedSecretPrefix) {
+		return nil, errors.New("not an encrypted secret")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(text, encryptedSecretPrefix))
+	if err != nil {
+		return nil, errors.New("not an encrypted secret")
+	}
+
+	if len(privateKey) != secretKeySize {
+		return nil, errors.New("invalid secret key")
+	}
+	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var public, private [secretKeySize]byte
+	copy(public[:], publicKey)
+	copy(private[:], privateKey)
+
+	plaintext, ok := box.OpenAnonymous(nil, sealed, &public, &private)
+	if !ok {
+		return nil, errors.New("failed to decrypt the secret. It was encrypted for a different key or has been changed")
+	}
+
+	return plaintext, nil
+}
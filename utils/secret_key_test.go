@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptSecret(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(SecretKeyEnv, "")
+
+	keyFile := filepath.Join(dir, "secret.key")
+	if err := GenerateSecretKey(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateSecretKey(keyFile); err == nil {
+		t.Error("expected an error when the key exists")
+	}
+
+	// encrypting only needs the public key
+	publicKey, err := LoadPublicKey(keyFile + PublicKeySuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := EncryptSecret(publicKey, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey, err := LoadSecretKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := DecryptSecret(privateKey, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted) != "hunter2" {
+		t.Errorf("expected hunter2, got %s", decrypted)
+	}
+
+	otherFile := filepath.Join(dir, "other.key")
+	if err = GenerateSecretKey(otherFile); err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := LoadSecretKey(otherFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = DecryptSecret(otherKey, encrypted); err == nil {
+		t.Error("expected an error decrypting with another key")
+	}
+	if _, err = DecryptSecret(publicKey, encrypted); err == nil {
+		t.Error("expected an error decrypting with the public key")
+	}
+}
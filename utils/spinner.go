@@ -3,27 +3,48 @@ package utils
 import (
 	"context"
 	"fmt"
-	"os"
+	"io"
 	"os/exec"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/kballard/go-shellquote"
-	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SpinnerKindStep is a spinner running a step command
+	SpinnerKindStep = "step"
+	// SpinnerKindProbe is a spinner running a step probe
+	SpinnerKindProbe = "probe"
+	// SpinnerKindPreflight is a spinner running a preflight check
+	SpinnerKindPreflight = "preflight"
 )
 
 // Spinner is the main component that runs a process
 type Spinner struct {
-	UUID string
-	Name string
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
 
 	cmd     string
 	args    []string
-	env     []string
 	timeout time.Duration
 	workdir string
 	step    Step
+	// streams controls where stdout and stderr go
+	streams *OutputDefinition
+
+	attempt   int
+	startedAt time.Time
+	endedAt   time.Time
+	exitCode  *int
+	signal    string
+	timedOut  bool
+	err       error
+	stdout    *OutputBuffer
+	stderr    *OutputBuffer
+	output    *OutputBuffer
 }
 
 // NewSpinnerForStep creates a new instance of Spinner based on the Options
@@ -77,11 +98,12 @@ func newSpinnerForStep(ctx context.Context, step Step) (*Spinner, error) {
 	return &Spinner{
 		UUID:    uuid.New().String(),
 		Name:    step.Name,
+		Kind:    SpinnerKindStep,
 		cmd:     parts[0],
 		args:    parts[1:],
 		step:    step,
-		env:     step.Env,
 		workdir: step.Workdir,
+		streams: step.Output,
 	}, nil
 }
 
@@ -105,12 +127,13 @@ func newSpinnerForPreflight(ctx context.Context, preflight *Preflight) (*Spinner
 	return &Spinner{
 		UUID:    uuid.New().String(),
 		Name:    fmt.Sprintf("%s.preflight", preflight.step.Name),
+		Kind:    SpinnerKindPreflight,
 		cmd:     parts[0],
 		args:    parts[1:],
 		step:    *preflight.step,
 		workdir: preflight.Workdir,
-		env:     preflight.step.Env,
 		timeout: timeout,
+		streams: mergeOutputDefinitions(preflight.Output, preflight.step.Output),
 	}, nil
 }
 
@@ -129,11 +152,12 @@ func newSpinnerForProbe(ctx context.Context, step Step) (*Spinner, error) {
 	return &Spinner{
 		UUID:    uuid.New().String(),
 		Name:    fmt.Sprintf("%s.probe", step.Name),
+		Kind:    SpinnerKindProbe,
 		cmd:     parts[0],
 		args:    parts[1:],
 		step:    step,
-		env:     step.Env,
 		workdir: step.Workdir,
+		streams: mergeOutputDefinitions(step.Probe.Output, step.Output),
 	}, nil
 }
 
@@ -155,6 +179,11 @@ func (s *Spinner) validate(ctx context.Context) {
 
 // Run runs the process required
 func (s *Spinner) Run(ctx context.Context) error {
+	s.attempt++
+	s.stdout = NewOutputBuffer(outputBufferSize)
+	s.stderr = NewOutputBuffer(outputBufferSize)
+	s.output = NewSpillingOutputBuffer(outputBufferSize, fmt.Sprintf("trackman-%s-*.log", s.step.SessionID))
+
 	s.push(ctx, NewEvent(s, EventRunRequested, nil))
 
 	cmdCtx, cancel := context.WithTimeout(ctx, s.timeout)
@@ -165,55 +194,187 @@ func (s *Spinner) Run(ctx context.Context) error {
 	// add this spinner to the context for the log writers
 	ctx = context.WithValue(ctx, CtxSpinner, s)
 
-	outChannel := NewLogWriter(ctx, logger, logrus.DebugLevel)
-	errChannel := NewLogWriter(ctx, logger, logrus.ErrorLevel)
+	stdoutLevel, stdoutLogged := s.streams.stdoutLevel()
+	outChannel := NewLogWriter(ctx, logger, stdoutLevel)
+	outChannel.buffer = s.stdout
+	outChannel.output = s.output
+	outChannel.silent = !stdoutLogged
+	stderrLevel, stderrLogged := s.streams.stderrLevel()
+	errChannel := NewLogWriter(ctx, logger, stderrLevel)
+	errChannel.buffer = s.stderr
+	errChannel.output = s.output
+	errChannel.silent = !stderrLogged
+
+	stdoutFile, stderrFile, err := s.openOutputFiles()
+	if err != nil {
+		s.startedAt = time.Now()
+		s.endedAt = s.startedAt
+		s.err = err
+		s.push(ctx, NewEvent(s, EventRunError, err))
+
+		return err
+	}
+	defer closeOutputFiles(stdoutFile, stderrFile)
 
 	logger.WithField(FldStep, s.Name).Tracef("Running %s with %s", s.cmd, s.args)
 
 	cmd := exec.CommandContext(cmdCtx, s.cmd, s.args...)
-	cmd.Stderr = errChannel
-	cmd.Stdout = outChannel
-	envs := os.Environ()
-	for _, env := range s.env {
-		envs = append(envs, env)
+	cmd.Stderr = outputWriter(errChannel, stderrFile)
+	cmd.Stdout = outputWriter(outChannel, stdoutFile)
+	envs := append(s.step.environment(), s.contextEnv()...)
+	if s.step.workflow.options.SpinnerEnv != nil {
+		envs = append(envs, s.step.workflow.options.SpinnerEnv(ctx, s)...)
 	}
 
 	cmd.Env = envs
 	cmd.Dir = s.workdir
 
-	err := cmd.Start()
+	s.startedAt = time.Now()
+	err = cmd.Start()
 	if err != nil {
-		s.push(ctx, NewEvent(s, EventRunError, nil))
+		s.closeOutput(outChannel, errChannel)
+		s.endedAt = time.Now()
+		s.err = err
+		s.push(ctx, NewEvent(s, EventRunError, err))
 
 		return err
 	}
 
 	s.push(ctx, NewEvent(s, EventRunStarted, nil))
 
-	if err := cmd.Wait(); err != nil {
-		if cmdCtx.Err() == context.DeadlineExceeded {
-			s.push(ctx, NewEvent(s, EventRunTimeout, nil))
-
-			return fmt.Errorf("Timed out after %s", s.timeout)
+	err = cmd.Wait()
+	// make sure all output is logged and buffered before reporting on it
+	s.closeOutput(outChannel, errChannel)
+	s.endedAt = time.Now()
+	if err == nil {
+		exitCode := 0
+		s.exitCode = &exitCode
+		// the full output of successful runs isn't needed
+		if err := s.output.Remove(); err != nil {
+			s.step.logger.WithField(FldStep, s.Name).Warnf("Failed to remove the output file: %s", err)
 		}
+		s.push(ctx, NewEvent(s, EventRunSuccess, nil))
+
+		return nil
+	}
+
+	// the full output of failed runs is kept for later
+	s.output.Keep()
+
+	exitErr, isExitErr := err.(*exec.ExitError)
+	if isExitErr {
+		s.setExitStatus(exitErr)
+	}
+
+	if cmdCtx.Err() == context.DeadlineExceeded {
+		s.timedOut = true
+		err = fmt.Errorf("Timed out after %s", s.timeout)
+		s.err = err
+		s.push(ctx, NewEvent(s, EventRunTimeout, err))
 
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			// The program has exited with an exit code != 0
-			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-				s.push(ctx, NewEvent(s, EventRunFail, status))
-				return exitErr
-			}
-		} else {
-			// wait error
-			s.push(ctx, NewEvent(s, EventRunWaitError, s))
-
-			return exitErr
+		return err
+	}
+
+	if isExitErr {
+		// The program has exited with an exit code != 0
+		s.err = exitErr
+		s.push(ctx, NewEvent(s, EventRunFail, exitErr))
+
+		return exitErr
+	}
+
+	// wait error
+	s.err = err
+	s.push(ctx, NewEvent(s, EventRunWaitError, err))
+
+	return err
+}
+
+// Output returns the most recent lines of stdout and stderr of the
+// process in the order they were written
+func (s *Spinner) Output() []string {
+	if s.output == nil {
+		return nil
+	}
+
+	return s.output.Lines()
+}
+
+// OutputFile returns the name of the file with all of the output of the
+// process. It is empty if all of the output is returned by Output
+func (s *Spinner) OutputFile() string {
+	if s.output == nil {
+		return ""
+	}
+
+	return s.output.File()
+}
+
+// FlushOutput makes sure the file returned by OutputFile has all of the
+// output written so far
+func (s *Spinner) FlushOutput() error {
+	if s.output == nil {
+		return nil
+	}
+
+	return s.output.Flush()
+}
+
+// closeOutput waits for all output to be logged and buffered
+func (s *Spinner) closeOutput(writers ...*LogWriter) {
+	for _, writer := range writers {
+		_ = writer.Close()
+	}
+
+	if err := s.output.Close(); err != nil {
+		s.step.logger.WithField(FldStep, s.Name).Warnf("Failed to write the output to %s: %s", s.output.File(), err)
+	}
+}
+
+// StdoutLogged returns true if the stdout of the process shows up in the logs
+func (s *Spinner) StdoutLogged() bool {
+	level, logged := s.streams.stdoutLevel()
+
+	return logged && s.step.logger.IsLevelEnabled(level)
+}
+
+// openOutputFiles opens the files the raw output of the process is written to, if any
+func (s *Spinner) openOutputFiles() (io.WriteCloser, io.WriteCloser, error) {
+	if s.streams == nil {
+		return nil, nil, nil
+	}
+
+	masker := s.step.workflow.Masker()
+	stdoutFile, err := outputFile(s.streams.StdoutFile, masker)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stderrFile, err := outputFile(s.streams.StderrFile, masker)
+	if err != nil {
+		closeOutputFiles(stdoutFile)
+		return nil, nil, err
+	}
+
+	return stdoutFile, stderrFile, nil
+}
+
+func closeOutputFiles(files ...io.WriteCloser) {
+	for _, file := range files {
+		if file != nil {
+			_ = file.Close()
 		}
 	}
+}
 
-	s.push(ctx, NewEvent(s, EventRunSuccess, nil))
+// setExitStatus records the exit code and the signal (if any) of the finished process
+func (s *Spinner) setExitStatus(exitErr *exec.ExitError) {
+	exitCode := exitErr.ExitCode()
+	s.exitCode = &exitCode
 
-	return nil
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		s.signal = status.Signal().String()
+	}
 }
 
 func (s *Spinner) push(ctx context.Context, event *Event) {
@@ -1,10 +1,8 @@
 package utils
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"html/template"
 	"strings"
 	"time"
 
@@ -15,6 +13,8 @@ const (
 	stepPending = 1
 	stepRunning = 2
 	stepDone    = 3
+	// stepCancelled is used when a step is stopped before running
+	stepCancelled = 4
 )
 
 // StepOptions provides options for a Step
@@ -24,13 +24,17 @@ type StepOptions struct {
 
 // Step is a single running Step
 type Step struct {
-	Metadata       map[string]string `yaml:"metadata" json:"metadata"`
+	Metadata       Metadata          `yaml:"metadata" json:"metadata"`
 	Name           string            `yaml:"name" json:"name"`
 	Command        string            `yaml:"command" json:"command"`
 	ContinueOnFail bool              `yaml:"continue_on_fail" json:"continue_on_fail"`
 	Timeout        *time.Duration    `yaml:"timeout" json:"timeout"`
 	Workdir        string            `yaml:"workdir" json:"workdir"`
 	Env            []string          `yaml:"env" json:"env"`
+	EnvFile        StringList        `yaml:"env_file" json:"env_file"`
+	InheritEnv     *bool             `yaml:"inherit_env" json:"inherit_env"`
+	AllowEnv       []string          `yaml:"allow_env" json:"allow_env"`
+	ExportMetadata *bool             `yaml:"export_metadata" json:"export_metadata"`
 	Probe          *Probe            `yaml:"probe" json:"probe"`
 	DependsOn      []string          `yaml:"depends_on" json:"depends_on"`
 	Preflights     []Preflight       `yaml:"preflights" json:"preflights"`
@@ -38,13 +42,27 @@ type Step struct {
 	ShowCommand    bool              `yaml:"show_command" json:"show_command"`
 	Disabled       bool              `yaml:"disabled" json:"disabled"`
 	Logger         *LogDefinition    `yaml:"logger" json:"logger"`
+	Output         *OutputDefinition `yaml:"output" json:"output"`
+	Uses           string            `yaml:"uses,omitempty" json:"uses,omitempty"`
+	With           Metadata          `yaml:"with,omitempty" json:"with,omitempty"`
 	SessionID      string
 
+	// id doesn't change when the name is templated and is shared with the
+	// copies of the step held by spinners
+	id        string
 	options   *StepOptions
 	workflow  *Workflow
 	logger    *logrus.Logger
 	status    int
 	dependsOn []*Step
+	startedAt time.Time
+	endedAt   time.Time
+	queuedAt  time.Time
+	queueWait time.Duration
+	record    *stepRecord
+	fileEnv   []string
+	// preflightsEnriched is set once the preflights are parsed
+	preflightsEnriched bool
 }
 
 // String overrides string
@@ -58,28 +76,28 @@ func (s *Step) String() string {
 	return str + "\n" + strings.Join(deps, ",")
 }
 
-// MergedMetadata merges step and workflow metadata
+// MergedMetadata merges step and workflow metadata. Nested values are
+// returned with their path, like db.host. Use MergedMetadataValues for the
+// values with their types
 func (s *Step) MergedMetadata() map[string]string {
+	return s.MergedMetadataValues().Flatten()
+}
+
+// MergedMetadataValues merges step and workflow metadata and keeps the types
+// of the values. Nested maps are merged key by key with the step values winning
+func (s *Step) MergedMetadataValues() Metadata {
 	if s.Metadata == nil {
 		return s.workflow.Metadata
 	}
 
-	result := make(map[string]string, len(s.workflow.Metadata))
-	for k, v := range s.workflow.Metadata {
-		result[k] = v
-	}
-
-	for k, v := range s.Metadata {
-		result[k] = v
-	}
-
-	return result
+	return mergeMetadata(s.workflow.Metadata, s.Metadata)
 }
 
 // shouldRun returns a step that can be run, hasn't started, isn't done and isn't marked to be done
 func (s *Step) shouldRun() bool {
 	// has this run or marked to run?
-	status := s.status != stepRunning && s.status != stepDone && s.status != stepPending
+	current := s.currentStatus()
+	status := current != stepRunning && current != stepDone && current != stepPending
 	if !status {
 		// if it has, then it shouldn't run
 		return false
@@ -98,36 +116,107 @@ func (s *Step) shouldRun() bool {
 }
 
 func (s *Step) isDone() bool {
-	return s.status == stepDone
+	return s.currentStatus() == stepDone
+}
+
+// currentStatus returns the status of the step. It is safe to call while the step runs
+func (s *Step) currentStatus() int {
+	s.workflow.state.Lock()
+	defer s.workflow.state.Unlock()
+
+	return s.status
+}
+
+// setName changes the name of the step after it's parsed. Reports read it while the step runs
+func (s *Step) setName(name string) {
+	s.workflow.updateState(func() {
+		s.Name = name
+	})
 }
 
 // MarkAsPending marks the step as pending meaning it's waiting to run
 func (s *Step) MarkAsPending() {
-	s.status = stepPending
+	s.workflow.updateState(func() {
+		s.status = stepPending
+	})
+	s.queuedAt = time.Now()
+}
+
+// ID returns a unique ID of the step in the workflow run
+func (s *Step) ID() string {
+	return s.id
+}
+
+// Workflow returns the workflow this step belongs to
+func (s *Step) Workflow() *Workflow {
+	return s.workflow
+}
+
+// MarkAsCancelled marks the step as cancelled meaning it was stopped before running
+func (s *Step) MarkAsCancelled() {
+	s.workflow.updateState(func() {
+		s.status = stepCancelled
+	})
+}
+
+// wasSkipped returns true if the step never got to run
+func (s *Step) wasSkipped() bool {
+	status := s.currentStatus()
+	return status != stepDone && status != stepCancelled
 }
 
 // GetMetaData returns metadata value of the key from this Step.
 // this is useful in event notifiers. It will return "" if there is
-// no metadata with the given key
+// no metadata with the given key. Lists and maps are returned as JSON
 func (s *Step) GetMetaData(key string) string {
-	if s.Metadata == nil {
-		s.Metadata = make(map[string]string)
+	return s.Metadata.String(key)
+}
+
+// StepOutput returns the stdout of the command of a finished step. Only the
+// most recent output is kept. It can be used in templates like {{ .StepOutput "build" }}
+func (s *Step) StepOutput(name string) (string, error) {
+	step := s.workflow.findStepByName(name)
+	if step == nil {
+		return "", fmt.Errorf("invalid step name %s", name)
+	}
+	if s.workflow.startedAt.IsZero() {
+		// the workflow is only being parsed
+		return fmt.Sprintf("[output of %s]", name), nil
+	}
+	if !step.isDone() {
+		return "", fmt.Errorf("step %s hasn't finished. Add it to depends_on of %s", name, s.Name)
 	}
 
-	return s.Metadata[key]
+	return strings.Join(step.record.stdoutLines(), "\n"), nil
 }
 
 // Run runs a Step and its probe
-func (s *Step) Run(ctx context.Context) error {
-	s.status = stepRunning
-	defer func() { s.status = stepDone }()
+func (s *Step) Run(ctx context.Context) (err error) {
+	s.workflow.updateState(func() {
+		s.status = stepRunning
+	})
+	defer s.workflow.updateState(func() {
+		s.status = stepDone
+	})
 
 	if s.Disabled {
-		s.logger.WithField(FldStep, s.Name).Info("Disabled step. Skipping")
+		s.push(ctx, NewStepEvent(s, EventStepDisabled, nil))
 		return nil
 	}
 
-	err := s.EnrichStep(ctx)
+	s.workflow.updateState(func() {
+		s.startedAt = time.Now()
+	})
+	s.push(ctx, NewStepEvent(s, EventStepStarted, nil))
+	defer func() {
+		s.workflow.updateState(func() {
+			s.endedAt = time.Now()
+		})
+		s.record.setError(err)
+		s.push(ctx, NewStepEvent(s, EventStepFinished, err))
+	}()
+
+	err = s.EnrichStep(ctx)
 	if err != nil {
 		return err
 	}
@@ -138,6 +227,8 @@ func (s *Step) Run(ctx context.Context) error {
 	}
 
 	err = spinner.Run(ctx)
+	s.record.addRun(spinner.Report())
+	s.record.setStdout(spinner.stdout)
 	if err != nil {
 		if !s.ContinueOnFail {
 			// main spinner failed and we need to get out
@@ -159,6 +250,7 @@ func (s *Step) Run(ctx context.Context) error {
 		probeSpinner.push(ctx, NewEvent(probeSpinner, EventRunningProbe, nil))
 
 		err = probeSpinner.Run(ctx)
+		s.record.addRun(probeSpinner.Report())
 		if err != nil {
 			// probe failed
 			if !s.ContinueOnFail {
@@ -172,25 +264,32 @@ func (s *Step) Run(ctx context.Context) error {
 	return nil
 }
 
+func (s *Step) push(ctx context.Context, event *Event) {
+	err := s.workflow.options.Notifier(ctx, s.logger, event)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
 // EnrichStep resolves environment variables and parses the command for the step
 // on all applicable attributes
 func (s *Step) EnrichStep(ctx context.Context) error {
 	var err error
 
 	// parse for meta data
-	if s.Metadata != nil {
-		for idx, metadata := range s.Metadata {
-			if s.Metadata[idx], err = s.parseAttribute(ctx, metadata); err != nil {
-				return err
-			}
-		}
+	if err = s.Metadata.transform(func(value string) (string, error) {
+		return s.parseAttribute(ctx, value)
+	}); err != nil {
+		return err
 	}
 	if s.Command, err = s.parseAttribute(ctx, s.Command); err != nil {
 		return err
 	}
-	if s.Name, err = s.parseAttribute(ctx, s.Name); err != nil {
+	name, err := s.parseAttribute(ctx, s.Name)
+	if err != nil {
 		return err
 	}
+	s.setName(name)
 	if s.Workdir, err = s.parseAttribute(ctx, s.Workdir); err != nil {
 		return err
 	}
@@ -216,27 +315,28 @@ func (s *Step) EnrichStep(ctx context.Context) error {
 			return err
 		}
 	}
-	if s.Preflights != nil {
-		for idx, preFlight := range s.Preflights {
-			if s.Preflights[idx].Command, err = s.parseAttribute(ctx, preFlight.Command); err != nil {
-				return err
-			}
-			if s.Preflights[idx].Workdir, err = s.parseAttribute(ctx, preFlight.Workdir); err != nil {
-				return err
-			}
-			if s.Preflights[idx].Message, err = s.parseAttribute(ctx, preFlight.Message); err != nil {
-				return err
-			}
+	if err = s.enrichOutput(ctx, s.Output); err != nil {
+		return err
+	}
+	if s.Probe != nil {
+		if err = s.enrichOutput(ctx, s.Probe.Output); err != nil {
+			return err
 		}
 	}
+	for idx, env := range s.Env {
+		if s.Env[idx], err = s.parseAttribute(ctx, env); err != nil {
+			return err
+		}
+	}
+	if err = s.enrichPreflights(ctx); err != nil {
+		return err
+	}
 
 	// expand env var
-	if s.Metadata != nil {
-		for idx, metadata := range s.Metadata {
-			if s.Metadata[idx], err = ExpandEnvVars(ctx, metadata); err != nil {
-				return err
-			}
-		}
+	if err = s.Metadata.transform(func(value string) (string, error) {
+		return ExpandEnvVars(ctx, value)
+	}); err != nil {
+		return err
 	}
 	if s.Command, err = ExpandEnvVars(ctx, s.Command); err != nil {
 		return err
@@ -247,9 +347,10 @@ func (s *Step) EnrichStep(ctx context.Context) error {
 	if s.Command, err = ExpandEnvVars(ctx, s.Command); err != nil {
 		return err
 	}
-	if s.Name, err = ExpandEnvVars(ctx, s.Name); err != nil {
+	if name, err = ExpandEnvVars(ctx, s.Name); err != nil {
 		return err
 	}
+	s.setName(name)
 	if s.Workdir, err = ExpandEnvVars(ctx, s.Workdir); err != nil {
 		return err
 	}
@@ -275,38 +376,93 @@ func (s *Step) EnrichStep(ctx context.Context) error {
 			return err
 		}
 	}
-	if s.Preflights != nil {
-		for idx, preFlight := range s.Preflights {
-			if s.Preflights[idx].Command, err = ExpandEnvVars(ctx, preFlight.Command); err != nil {
-				return err
-			}
-			if s.Preflights[idx].Workdir, err = ExpandEnvVars(ctx, preFlight.Workdir); err != nil {
-				return err
-			}
-			if s.Preflights[idx].Message, err = ExpandEnvVars(ctx, preFlight.Message); err != nil {
-				return err
-			}
+
+	// enriched metadata can hold secrets that weren't there before
+	if masker := s.workflow.Masker(); masker != nil {
+		masker.addStep(s, declaredSecrets(s.workflow.secretNames()))
+	}
+
+	return nil
+}
+
+// enrichPreflights parses the preflights of the step. Preflights run before
+// the step so they are parsed on their own, and only once
+func (s *Step) enrichPreflights(ctx context.Context) error {
+	if s.preflightsEnriched {
+		return nil
+	}
+
+	var err error
+	for idx, preFlight := range s.Preflights {
+		if err = s.enrichOutput(ctx, preFlight.Output); err != nil {
+			return err
+		}
+		if s.Preflights[idx].Command, err = s.parseAttribute(ctx, preFlight.Command); err != nil {
+			return err
+		}
+		if s.Preflights[idx].Workdir, err = s.parseAttribute(ctx, preFlight.Workdir); err != nil {
+			return err
+		}
+		if s.Preflights[idx].Message, err = s.parseAttribute(ctx, preFlight.Message); err != nil {
+			return err
+		}
+		if s.Preflights[idx].Command, err = ExpandEnvVars(ctx, s.Preflights[idx].Command); err != nil {
+			return err
+		}
+		if s.Preflights[idx].Workdir, err = ExpandEnvVars(ctx, s.Preflights[idx].Workdir); err != nil {
+			return err
+		}
+		if s.Preflights[idx].Message, err = ExpandEnvVars(ctx, s.Preflights[idx].Message); err != nil {
+			return err
 		}
 	}
+	s.preflightsEnriched = true
 
 	return nil
 }
 
-func (s *Step) parseAttribute(ctx context.Context, value string) (string, error) {
-	if value == "" {
-		return "", nil
+// enrichOutput parses the file names of an output definition
+func (s *Step) enrichOutput(ctx context.Context, definition *OutputDefinition) error {
+	if definition == nil {
+		return nil
 	}
 
-	buf := &bytes.Buffer{}
-	tmpl, err := template.New("step").Parse(value)
-	if err != nil {
-		return "", err
+	var err error
+	if definition.StdoutFile, err = s.parseAttribute(ctx, definition.StdoutFile); err != nil {
+		return err
+	}
+	if definition.StdoutFile, err = ExpandEnvVars(ctx, definition.StdoutFile); err != nil {
+		return err
+	}
+	if definition.StderrFile, err = s.parseAttribute(ctx, definition.StderrFile); err != nil {
+		return err
+	}
+	if definition.StderrFile, err = ExpandEnvVars(ctx, definition.StderrFile); err != nil {
+		return err
 	}
 
-	err = tmpl.Execute(buf, s)
-	if err != nil {
-		return "", err
+	return nil
+}
+
+// validateOutput checks the output definitions of the step, its probe and its preflights
+func (s *Step) validateOutput() error {
+	definitions := []*OutputDefinition{s.Output}
+	if s.Probe != nil {
+		definitions = append(definitions, s.Probe.Output)
+	}
+	for _, preflight := range s.Preflights {
+		definitions = append(definitions, preflight.Output)
 	}
 
-	return buf.String(), nil
+	for _, definition := range definitions {
+		if err := definition.validate(); err != nil {
+			return fmt.Errorf("invalid output for step %s: %s", s.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Step) parseAttribute(ctx context.Context, value string) (string, error) {
+	return renderTemplate("step", value, s)
 }
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// templateFuncs are the functions available to all templates in a workflow
+var templateFuncs = template.FuncMap{
+	"default": templateDefault,
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"trim":    strings.TrimSpace,
+	"replace": func(old, new, value string) string { return strings.Replace(value, old, new, -1) },
+	"quote":   func(value interface{}) string { return fmt.Sprintf("%q", fmt.Sprint(value)) },
+	"b64enc":  func(value string) string { return base64.StdEncoding.EncodeToString([]byte(value)) },
+	"b64dec":  templateBase64Decode,
+	"toJson":  templateToJSON,
+	"env":     os.Getenv,
+	"now":     time.Now,
+	"uuid":    func() string { return uuid.New().String() },
+}
+
+// renderTemplate renders value as a template with data. Keys missing from
+// maps are errors instead of empty values
+func renderTemplate(name string, value string, data interface{}) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", err
+	}
+
+	buf := &bytes.Buffer{}
+	if err = tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// templateDefault returns value unless it's empty, like "", 0, false or nil,
+// in which case it returns fallback. It's used as {{ .Value | default "x" }}
+func templateDefault(fallback interface{}, value ...interface{}) interface{} {
+	if len(value) == 0 || isEmptyValue(value[0]) {
+		return fallback
+	}
+
+	return value[0]
+}
+
+func isEmptyValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	default:
+		return v.IsZero()
+	}
+}
+
+func templateBase64Decode(value string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(decoded), nil
+}
+
+func templateToJSON(value interface{}) (string, error) {
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
@@ -1,16 +1,15 @@
 package utils
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"html/template"
 	"io"
 	"io/ioutil"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/hashicorp/go-multierror"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -19,20 +18,55 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+// idleWait is how long the workflow waits before checking again when no step is ready to run
+const idleWait = 10 * time.Millisecond
+
 // WorkflowOptions provides options for a workflow
 type WorkflowOptions struct {
-	Notifier    func(ctx context.Context, logger *logrus.Logger, event *Event) error
+	Notifier func(ctx context.Context, logger *logrus.Logger, event *Event) error
+	// SpinnerEnv returns extra environment variables for a spinner's process. It is optional
+	SpinnerEnv func(ctx context.Context, spinner *Spinner) []string
+	// Confirm asks if a step with ask_to_proceed should run. It is optional and
+	// defaults to asking on the terminal
+	Confirm     func(ctx context.Context, step *Step) bool
 	Concurrency int
 	Timeout     time.Duration
 	Metadata    map[string]string
+	// MetadataValues is metadata that keeps its types, like lists and nested
+	// maps. It wins over Metadata
+	MetadataValues Metadata
+	// MetadataSources are where the values of MetadataValues came from, by
+	// their path. Use AddMetadata to keep them up to date
+	MetadataSources map[string]string
+	// BaseDir is the directory relative paths in the workflow are based on. It
+	// defaults to the current directory
+	BaseDir string
+	// File is the workflow file, if the workflow was read from one. It's used
+	// to find imports of the workflow itself
+	File string
+	// SecretKeyFile is the key used to decrypt encrypted secrets. It defaults
+	// to the key in the home directory
+	SecretKeyFile string
+	// Params are the values of the workflow params by name
+	Params map[string]interface{}
+	// Prompt asks for the value of a required param that isn't in Params. It is optional
+	Prompt func(param *ParamDefinition) (string, error)
 }
 
 // Workflow is the internal object to hold a workflow file
 type Workflow struct {
-	Version  string            `yaml:"version" json:"version"`
-	Metadata map[string]string `yaml:"metadata" json:"metadata"`
-	Steps    []*Step           `yaml:"steps" json:"steps"`
-	Logger   *LogDefinition    `yaml:"logger" json:"logger"`
+	Name             string              `yaml:"name" json:"name"`
+	Version          string              `yaml:"version" json:"version"`
+	Metadata         Metadata            `yaml:"metadata" json:"metadata"`
+	ParamDefinitions []*ParamDefinition  `yaml:"params" json:"params"`
+	Secrets          []*SecretDefinition `yaml:"secrets" json:"secrets"`
+	Env              []string            `yaml:"env" json:"env"`
+	EnvFile          StringList          `yaml:"env_file" json:"env_file"`
+	InheritEnv       *bool               `yaml:"inherit_env" json:"inherit_env"`
+	AllowEnv         []string            `yaml:"allow_env" json:"allow_env"`
+	ExportMetadata   *bool               `yaml:"export_metadata" json:"export_metadata"`
+	Steps            []*Step             `yaml:"steps" json:"steps"`
+	Logger           *LogDefinition      `yaml:"logger" json:"logger"`
 
 	options    *WorkflowOptions
 	logger     *logrus.Logger
@@ -40,15 +74,19 @@ type Workflow struct {
 	signal     *sync.Mutex
 	stopFlag   bool
 	sessionID  string
+	masker     *Masker
+	params     map[string]interface{}
+	fileEnv    []string
+	startedAt  time.Time
+	endedAt    time.Time
+	err        error
+	// state guards the status and times of the workflow and its steps, which
+	// reports read while the steps run
+	state sync.Mutex
 }
 
 // LoadWorkflowFromBytes loads a workflow from bytes
 func LoadWorkflowFromBytes(ctx context.Context, options *WorkflowOptions, buff []byte) (*Workflow, error) {
-	var workflow *Workflow
-	err := yaml.Unmarshal(buff, &workflow)
-	if err != nil {
-		return nil, err
-	}
 	if options == nil {
 		panic("no options")
 	}
@@ -56,6 +94,19 @@ func LoadWorkflowFromBytes(ctx context.Context, options *WorkflowOptions, buff [
 		panic("no notifier")
 	}
 
+	// imports and templates are resolved before anything else so their
+	// steps are like any other step
+	buff, err := resolveImports(options, buff)
+	if err != nil {
+		return nil, err
+	}
+
+	var workflow *Workflow
+	err = yaml.Unmarshal(buff, &workflow)
+	if err != nil {
+		return nil, err
+	}
+
 	if workflow.Version != "1" {
 		return nil, errors.New("invalid workflow version")
 	}
@@ -66,8 +117,27 @@ func LoadWorkflowFromBytes(ctx context.Context, options *WorkflowOptions, buff [
 	workflow.stopFlag = false
 	workflow.signal = &sync.Mutex{}
 
+	for _, secret := range workflow.Secrets {
+		if err = secret.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = workflow.loadParams(); err != nil {
+		return nil, err
+	}
+	if err = workflow.loadEnvFiles(); err != nil {
+		return nil, err
+	}
+
 	// merge options metadata with yaml
-	workflow.Metadata = mergeMaps(workflow.Metadata, workflow.options.Metadata, true)
+	flat := make(Metadata, len(workflow.options.Metadata))
+	for key, value := range workflow.options.Metadata {
+		flat[key] = value
+	}
+	workflow.Metadata = mergeMetadata(workflow.Metadata, flat)
+	workflow.Metadata = mergeMetadata(workflow.Metadata, workflow.options.MetadataValues)
+	workflow.masker = newWorkflowMasker(workflow)
 
 	logger, err := NewLogger(workflow.Logger, NewLoggingContext(workflow, nil))
 	if err != nil {
@@ -80,6 +150,11 @@ func LoadWorkflowFromBytes(ctx context.Context, options *WorkflowOptions, buff [
 	for idx, step := range workflow.Steps {
 		workflow.Steps[idx].SessionID = workflow.SessionID()
 		workflow.Steps[idx].workflow = workflow
+		workflow.Steps[idx].record = &stepRecord{}
+		workflow.Steps[idx].id = uuid.New().String()
+		if err = step.validateOutput(); err != nil {
+			return nil, err
+		}
 		for _, priorStepName := range step.DependsOn {
 			priorStep := workflow.findStepByName(priorStepName)
 			if priorStep == nil {
@@ -107,6 +182,9 @@ func LoadWorkflowFromBytes(ctx context.Context, options *WorkflowOptions, buff [
 	if err = workflow.EnrichWorkflow(ctx); err != nil {
 		return workflow, err
 	}
+	// templates can turn metadata and env into secrets
+	workflow.masker.addMetadata(workflow.Metadata, declaredSecrets(workflow.secretNames()))
+	workflow.masker.addEnvironment(workflow.Env, declaredSecrets(workflow.secretNames()))
 
 	return workflow, nil
 }
@@ -121,6 +199,15 @@ func LoadWorkflowFromReader(ctx context.Context, options *WorkflowOptions, reade
 	return LoadWorkflowFromBytes(ctx, options, buff)
 }
 
+// Masker returns the masker of secrets used by this workflow
+func (w *Workflow) Masker() *Masker {
+	if w == nil {
+		return nil
+	}
+
+	return w.masker
+}
+
 // SessionID returns the session id of this run for the workflow
 func (w *Workflow) SessionID() string {
 	return w.sessionID
@@ -138,25 +225,71 @@ func (w *Workflow) preflights(ctx context.Context) (preflights []*Preflight) {
 }
 
 func (w *Workflow) preflightChecks(ctx context.Context) error {
+	w.push(ctx, NewWorkflowEvent(w, EventPreflightStarted, nil))
+
 	for _, preflight := range w.preflights(ctx) {
+		if err := preflight.step.enrichPreflights(ctx); err != nil {
+			w.push(ctx, NewStepEvent(preflight.step, EventPreflightFailed, err))
+
+			return err
+		}
+
 		err := preflight.Run(ctx)
 		if err != nil {
 			if preflight.Message != "" {
 				// dump the message
 				w.logger.WithField(FldStep, fmt.Sprintf("%s.preflight", preflight.step.Name)).Error(preflight.Message)
 			}
+
+			w.push(ctx, NewStepEvent(preflight.step, EventPreflightFailed, err))
+
 			return err
 		}
 	}
 
+	w.push(ctx, NewWorkflowEvent(w, EventPreflightPassed, nil))
+
 	return nil
 }
 
+// updateState changes the status or times of the workflow or its steps
+func (w *Workflow) updateState(update func()) {
+	w.state.Lock()
+	defer w.state.Unlock()
+
+	update()
+}
+
 // Run runs the entire workflow
 func (w *Workflow) Run(ctx context.Context) (runErrors error, stepErrors error) {
 	// if w.Logger is null, it's going to use the defaults which should be the same as with the app
 	// since the default values from from the same place
 	w.logger.Infof("Running Workflow with Session ID %s", w.sessionID)
+	w.updateState(func() {
+		w.startedAt = time.Now()
+	})
+	w.push(ctx, NewWorkflowEvent(w, EventWorkflowStarted, nil))
+	defer func() {
+		err := runErrors
+		if err == nil {
+			err = stepErrors
+		}
+		w.updateState(func() {
+			w.endedAt = time.Now()
+			w.err = err
+		})
+
+		if err != nil {
+			w.push(ctx, NewWorkflowEvent(w, EventWorkflowFailed, err))
+		} else {
+			w.push(ctx, NewWorkflowEvent(w, EventWorkflowFinished, nil))
+		}
+	}()
+
+	if err := w.loadSecrets(ctx); err != nil {
+		return err, nil
+	}
+
 	w.logger.Info("Running Preflight checks")
 	err := w.preflightChecks(ctx)
 	if err != nil {
@@ -165,18 +298,26 @@ func (w *Workflow) Run(ctx context.Context) (runErrors error, stepErrors error)
 	w.logger.Info("Preflight checks complete")
 
 	joiner := sync.WaitGroup{}
+	errorsLock := sync.Mutex{}
 
 	// Run all that can run
 	for {
 		if w.shouldStop(ctx) {
-			return nil, stepErrors
+			break
 		}
 		if w.allDone() {
 			break
 		}
+		if ctx.Err() != nil {
+			// the run was cancelled
+			runErrors = ctx.Err()
+			break
+		}
 
 		step := w.nextToRun(ctx)
 		if step == nil {
+			// wait for a running step to finish
+			time.Sleep(idleWait)
 			continue
 		}
 
@@ -184,25 +325,28 @@ func (w *Workflow) Run(ctx context.Context) (runErrors error, stepErrors error)
 
 		err := w.gatekeeper.Acquire(ctx, 1)
 		if err != nil {
-			return err, nil
+			runErrors = err
+			break
 		}
 
 		if w.shouldStop(ctx) {
-			return
+			w.gatekeeper.Release(1)
+			break
 		}
 
 		joiner.Add(1)
 		go func(toRun *Step) {
-			if w.shouldStop(ctx) {
-				return
-			}
-
+			toRun.queueWait = time.Since(toRun.queuedAt)
 			defer func() {
 				w.logger.WithField(FldStep, toRun.Name).Trace("Done running")
 				w.gatekeeper.Release(1)
 				joiner.Done()
 			}()
 
+			if w.shouldStop(ctx) {
+				return
+			}
+
 			w.logger.WithField(FldStep, toRun.Name).Trace("Preparing to run")
 
 			if toRun.ShowCommand {
@@ -211,15 +355,28 @@ func (w *Workflow) Run(ctx context.Context) (runErrors error, stepErrors error)
 
 			if !toRun.Disabled && toRun.AskToProceed && !viper.GetBool("confirm.yes") {
 				// we need an interactive permission for this
-				if !confirm(fmt.Sprintf("Run %s?", toRun.Name), 1) {
+				toRun.push(ctx, NewStepEvent(toRun, EventConfirmationAsked, nil))
+				answer := w.confirm(ctx, toRun)
+				answered := NewStepEvent(toRun, EventConfirmationAnswered, nil)
+				answered.Payload.Confirmed = &answer
+				toRun.push(ctx, answered)
+
+				if !answer {
 					w.logger.WithField(FldStep, toRun.Name).Info("Stopping execution")
+					toRun.MarkAsCancelled()
+					toRun.push(ctx, NewStepEvent(toRun, EventStepCancelled, nil))
 					w.stop(ctx)
+
+					return
 				}
 			}
 
 			err := toRun.Run(ctx)
 			if err != nil {
+				errorsLock.Lock()
 				stepErrors = multierror.Append(err, stepErrors)
+				errorsLock.Unlock()
+
 				// run failed in some way that the whole workflow should stop
 				w.logger.WithField(FldStep, toRun.Name).Error(err)
 				w.logger.WithField(FldStep, toRun.Name).Error("Calling a stop to run")
@@ -230,7 +387,36 @@ func (w *Workflow) Run(ctx context.Context) (runErrors error, stepErrors error)
 
 	joiner.Wait()
 
-	return nil, stepErrors
+	// anything that hasn't run by now is never going to
+	for _, step := range w.Steps {
+		if !step.wasSkipped() {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			step.MarkAsCancelled()
+			step.push(ctx, NewStepEvent(step, EventStepCancelled, ctx.Err()))
+		} else {
+			step.push(ctx, NewStepEvent(step, EventStepSkipped, nil))
+		}
+	}
+
+	return runErrors, stepErrors
+}
+
+func (w *Workflow) push(ctx context.Context, event *Event) {
+	err := w.options.Notifier(ctx, w.logger, event)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (w *Workflow) confirm(ctx context.Context, step *Step) bool {
+	if w.options.Confirm != nil {
+		return w.options.Confirm(ctx, step)
+	}
+
+	return confirm(fmt.Sprintf("Run %s?", step.Name), 1)
 }
 
 // nextToRun returns the next step that can run
@@ -291,19 +477,20 @@ func (w *Workflow) EnrichWorkflow(ctx context.Context) error {
 	var err error
 
 	// meta data first
-	if w.Metadata != nil {
-		for idx, metadata := range w.Metadata {
-			if w.Metadata[idx], err = w.parseAttribute(ctx, metadata); err != nil {
-				return err
-			}
-		}
+	if err = w.Metadata.transform(func(value string) (string, error) {
+		return w.parseAttribute(ctx, value)
+	}); err != nil {
+		return err
 	}
 
-	if w.Metadata != nil {
-		for idx, metadata := range w.Metadata {
-			if w.Metadata[idx], err = ExpandEnvVars(ctx, metadata); err != nil {
-				return err
-			}
+	if err = w.Metadata.transform(func(value string) (string, error) {
+		return ExpandEnvVars(ctx, value)
+	}); err != nil {
+		return err
+	}
+	for idx, env := range w.Env {
+		if w.Env[idx], err = w.parseAttribute(ctx, env); err != nil {
+			return err
 		}
 	}
 
@@ -311,20 +498,5 @@ func (w *Workflow) EnrichWorkflow(ctx context.Context) error {
 }
 
 func (w *Workflow) parseAttribute(ctx context.Context, value string) (string, error) {
-	if value == "" {
-		return "", nil
-	}
-
-	buf := &bytes.Buffer{}
-	tmpl, err := template.New("workflow").Parse(value)
-	if err != nil {
-		return "", err
-	}
-
-	err = tmpl.Execute(buf, w)
-	if err != nil {
-		return "", err
-	}
-
-	return buf.String(), nil
+	return renderTemplate("workflow", value, w)
 }